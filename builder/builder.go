@@ -0,0 +1,337 @@
+// Package builder renders SELECT, INSERT, UPDATE and DELETE statements
+// using the placeholder and identifier quoting rules of a database dialect.
+//
+//	b := builder.New(dbConn.Dialect())
+//	rows, err := dbConn.QueryFrom(b.Select("identity").
+//		From("data_domain_identities").
+//		Where(builder.In("domain_name", "sales", "hr")).
+//		OrderBy("identity").
+//		Limit(100))
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Dialect is the subset of database.Dialect the builder needs
+type Dialect interface {
+	QuoteIdent(name string) string
+	Placeholder(n int) string
+	LimitOffset(limit, offset int) string
+	Returning(columns []string) string
+}
+
+// Builder creates statements for a single dialect
+type Builder struct {
+	dialect Dialect
+}
+
+// New creates a builder for the given dialect
+func New(dialect Dialect) *Builder {
+	return &Builder{dialect: dialect}
+}
+
+// plainIdent matches the table and column names the builder accepts,
+// optionally qualified. Anything else, such as COUNT(*) or an alias
+// expression, is rejected rather than written into the SQL text.
+var plainIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// writer accumulates SQL text and its arguments, and the first error
+// found while rendering
+type writer struct {
+	dialect Dialect
+	sb      strings.Builder
+	args    []interface{}
+	err     error
+}
+
+// fail records a rendering error; only the first one is kept
+func (w *writer) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf(format, args...)
+	}
+}
+
+// result returns the rendered statement, or the first rendering error
+func (w *writer) result() (string, []interface{}, error) {
+	if w.err != nil {
+		return "", nil, w.err
+	}
+	return w.sb.String(), w.args, nil
+}
+
+// ident writes a quoted identifier, rejecting names that aren't plain
+func (w *writer) ident(name string) {
+	if !plainIdent.MatchString(name) {
+		w.fail("invalid identifier %q", name)
+		return
+	}
+	w.sb.WriteString(w.dialect.QuoteIdent(name))
+}
+
+// idents writes a comma-separated list of identifiers
+func (w *writer) idents(names []string) {
+	for i, name := range names {
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		w.ident(name)
+	}
+}
+
+// arg writes a placeholder and records its value
+func (w *writer) arg(v interface{}) {
+	w.args = append(w.args, v)
+	w.sb.WriteString(w.dialect.Placeholder(len(w.args)))
+}
+
+// where writes a WHERE clause joining conds with AND
+func (w *writer) where(conds []Cond) {
+	if len(conds) == 0 {
+		return
+	}
+	w.sb.WriteString(" WHERE ")
+	And(conds...).render(w)
+}
+
+// order is a single ORDER BY term
+type order struct {
+	column string
+	desc   bool
+}
+
+// SelectBuilder builds a SELECT statement
+type SelectBuilder struct {
+	dialect Dialect
+	columns []string
+	table   string
+	where   []Cond
+	orderBy []order
+	limit   int
+	offset  int
+}
+
+// Select starts a SELECT of the given columns; no columns selects *
+func (b *Builder) Select(columns ...string) *SelectBuilder {
+	return &SelectBuilder{dialect: b.dialect, columns: columns}
+}
+
+// From sets the table to select from
+func (s *SelectBuilder) From(table string) *SelectBuilder {
+	s.table = table
+	return s
+}
+
+// Where adds conditions, joined with AND to any existing ones
+func (s *SelectBuilder) Where(conds ...Cond) *SelectBuilder {
+	s.where = append(s.where, conds...)
+	return s
+}
+
+// OrderBy adds ascending sort columns
+func (s *SelectBuilder) OrderBy(columns ...string) *SelectBuilder {
+	for _, column := range columns {
+		s.orderBy = append(s.orderBy, order{column: column})
+	}
+	return s
+}
+
+// OrderByDesc adds descending sort columns
+func (s *SelectBuilder) OrderByDesc(columns ...string) *SelectBuilder {
+	for _, column := range columns {
+		s.orderBy = append(s.orderBy, order{column: column, desc: true})
+	}
+	return s
+}
+
+// Limit caps the number of rows returned
+func (s *SelectBuilder) Limit(limit int) *SelectBuilder {
+	s.limit = limit
+	return s
+}
+
+// Offset skips the given number of rows
+func (s *SelectBuilder) Offset(offset int) *SelectBuilder {
+	s.offset = offset
+	return s
+}
+
+// ToSQL renders the statement and its arguments
+func (s *SelectBuilder) ToSQL() (string, []interface{}, error) {
+	w := &writer{dialect: s.dialect}
+	w.sb.WriteString("SELECT ")
+	if len(s.columns) == 0 {
+		w.sb.WriteByte('*')
+	} else {
+		w.idents(s.columns)
+	}
+	if s.table != "" {
+		w.sb.WriteString(" FROM ")
+		w.ident(s.table)
+	}
+	w.where(s.where)
+	for i, o := range s.orderBy {
+		if i == 0 {
+			w.sb.WriteString(" ORDER BY ")
+		} else {
+			w.sb.WriteString(", ")
+		}
+		w.ident(o.column)
+		if o.desc {
+			w.sb.WriteString(" DESC")
+		}
+	}
+	w.sb.WriteString(s.dialect.LimitOffset(s.limit, s.offset))
+	return w.result()
+}
+
+// InsertBuilder builds an INSERT statement
+type InsertBuilder struct {
+	dialect   Dialect
+	table     string
+	columns   []string
+	rows      [][]interface{}
+	returning []string
+}
+
+// Insert starts an INSERT into table
+func (b *Builder) Insert(table string) *InsertBuilder {
+	return &InsertBuilder{dialect: b.dialect, table: table}
+}
+
+// Columns sets the columns being inserted
+func (i *InsertBuilder) Columns(columns ...string) *InsertBuilder {
+	i.columns = columns
+	return i
+}
+
+// Values adds a row of values; call it repeatedly for multi-row inserts
+func (i *InsertBuilder) Values(values ...interface{}) *InsertBuilder {
+	i.rows = append(i.rows, values)
+	return i
+}
+
+// Returning requests columns back from the inserted rows on dialects
+// that support it
+func (i *InsertBuilder) Returning(columns ...string) *InsertBuilder {
+	i.returning = columns
+	return i
+}
+
+// ToSQL renders the statement and its arguments
+func (i *InsertBuilder) ToSQL() (string, []interface{}, error) {
+	if len(i.columns) == 0 {
+		return "", nil, fmt.Errorf("insert into %s: no columns", i.table)
+	}
+	if len(i.rows) == 0 {
+		return "", nil, fmt.Errorf("insert into %s: no values", i.table)
+	}
+
+	w := &writer{dialect: i.dialect}
+	w.sb.WriteString("INSERT INTO ")
+	w.ident(i.table)
+	w.sb.WriteString(" (")
+	w.idents(i.columns)
+	w.sb.WriteString(") VALUES ")
+	for r, row := range i.rows {
+		if len(row) != len(i.columns) {
+			return "", nil, fmt.Errorf("insert into %s: row %d has %d values, want %d",
+				i.table, r, len(row), len(i.columns))
+		}
+		if r > 0 {
+			w.sb.WriteString(", ")
+		}
+		w.sb.WriteByte('(')
+		for c, v := range row {
+			if c > 0 {
+				w.sb.WriteString(", ")
+			}
+			w.arg(v)
+		}
+		w.sb.WriteByte(')')
+	}
+	w.sb.WriteString(i.dialect.Returning(i.returning))
+	return w.result()
+}
+
+// assignment is a single SET column = value
+type assignment struct {
+	column string
+	value  interface{}
+}
+
+// UpdateBuilder builds an UPDATE statement
+type UpdateBuilder struct {
+	dialect Dialect
+	table   string
+	set     []assignment
+	where   []Cond
+}
+
+// Update starts an UPDATE of table
+func (b *Builder) Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{dialect: b.dialect, table: table}
+}
+
+// Set assigns value to column
+func (u *UpdateBuilder) Set(column string, value interface{}) *UpdateBuilder {
+	u.set = append(u.set, assignment{column, value})
+	return u
+}
+
+// Where adds conditions, joined with AND to any existing ones
+func (u *UpdateBuilder) Where(conds ...Cond) *UpdateBuilder {
+	u.where = append(u.where, conds...)
+	return u
+}
+
+// ToSQL renders the statement and its arguments
+func (u *UpdateBuilder) ToSQL() (string, []interface{}, error) {
+	if len(u.set) == 0 {
+		return "", nil, fmt.Errorf("update %s: no columns set", u.table)
+	}
+
+	w := &writer{dialect: u.dialect}
+	w.sb.WriteString("UPDATE ")
+	w.ident(u.table)
+	w.sb.WriteString(" SET ")
+	for i, a := range u.set {
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		w.ident(a.column)
+		w.sb.WriteString(" = ")
+		w.arg(a.value)
+	}
+	w.where(u.where)
+	return w.result()
+}
+
+// DeleteBuilder builds a DELETE statement
+type DeleteBuilder struct {
+	dialect Dialect
+	table   string
+	where   []Cond
+}
+
+// Delete starts a DELETE from table
+func (b *Builder) Delete(table string) *DeleteBuilder {
+	return &DeleteBuilder{dialect: b.dialect, table: table}
+}
+
+// Where adds conditions, joined with AND to any existing ones
+func (d *DeleteBuilder) Where(conds ...Cond) *DeleteBuilder {
+	d.where = append(d.where, conds...)
+	return d
+}
+
+// ToSQL renders the statement and its arguments
+func (d *DeleteBuilder) ToSQL() (string, []interface{}, error) {
+	w := &writer{dialect: d.dialect}
+	w.sb.WriteString("DELETE FROM ")
+	w.ident(d.table)
+	w.where(d.where)
+	return w.result()
+}
@@ -0,0 +1,182 @@
+package builder
+
+import "strings"
+
+// Cond is a boolean SQL expression used in WHERE clauses
+type Cond interface {
+	render(w *writer)
+}
+
+// compare is a binary comparison between a column and a value
+type compare struct {
+	column string
+	op     string
+	value  interface{}
+}
+
+func (c compare) render(w *writer) {
+	w.ident(c.column)
+	w.sb.WriteString(" " + c.op + " ")
+	w.arg(c.value)
+}
+
+// Eq returns column = value
+func Eq(column string, value interface{}) Cond { return compare{column, "=", value} }
+
+// NotEq returns column <> value
+func NotEq(column string, value interface{}) Cond { return compare{column, "<>", value} }
+
+// Lt returns column < value
+func Lt(column string, value interface{}) Cond { return compare{column, "<", value} }
+
+// Lte returns column <= value
+func Lte(column string, value interface{}) Cond { return compare{column, "<=", value} }
+
+// Gt returns column > value
+func Gt(column string, value interface{}) Cond { return compare{column, ">", value} }
+
+// Gte returns column >= value
+func Gte(column string, value interface{}) Cond { return compare{column, ">=", value} }
+
+// Like returns column LIKE pattern
+func Like(column string, pattern string) Cond { return compare{column, "LIKE", pattern} }
+
+// nullCheck is an IS [NOT] NULL test
+type nullCheck struct {
+	column string
+	not    bool
+}
+
+func (c nullCheck) render(w *writer) {
+	w.ident(c.column)
+	if c.not {
+		w.sb.WriteString(" IS NOT NULL")
+	} else {
+		w.sb.WriteString(" IS NULL")
+	}
+}
+
+// IsNull returns column IS NULL
+func IsNull(column string) Cond { return nullCheck{column: column} }
+
+// IsNotNull returns column IS NOT NULL
+func IsNotNull(column string) Cond { return nullCheck{column: column, not: true} }
+
+// in is a column IN (...) membership test
+type in struct {
+	column string
+	values []interface{}
+	not    bool
+}
+
+func (c in) render(w *writer) {
+	if len(c.values) == 0 {
+		// An empty IN list is a syntax error in SQL; render the constant result
+		if c.not {
+			w.sb.WriteString("1 = 1")
+		} else {
+			w.sb.WriteString("1 = 0")
+		}
+		return
+	}
+	w.ident(c.column)
+	if c.not {
+		w.sb.WriteString(" NOT IN (")
+	} else {
+		w.sb.WriteString(" IN (")
+	}
+	for i, v := range c.values {
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		w.arg(v)
+	}
+	w.sb.WriteByte(')')
+}
+
+// In returns column IN (values...)
+func In(column string, values ...interface{}) Cond { return in{column: column, values: values} }
+
+// NotIn returns column NOT IN (values...)
+func NotIn(column string, values ...interface{}) Cond {
+	return in{column: column, values: values, not: true}
+}
+
+// junction joins conditions with AND or OR
+type junction struct {
+	op    string
+	conds []Cond
+}
+
+func (j junction) render(w *writer) {
+	if len(j.conds) == 0 {
+		// Empty AND is true, empty OR is false
+		if j.op == "AND" {
+			w.sb.WriteString("1 = 1")
+		} else {
+			w.sb.WriteString("1 = 0")
+		}
+		return
+	}
+	if len(j.conds) == 1 {
+		j.conds[0].render(w)
+		return
+	}
+	w.sb.WriteByte('(')
+	for i, c := range j.conds {
+		if i > 0 {
+			w.sb.WriteString(" " + j.op + " ")
+		}
+		c.render(w)
+	}
+	w.sb.WriteByte(')')
+}
+
+// And joins conditions with AND
+func And(conds ...Cond) Cond { return junction{"AND", conds} }
+
+// Or joins conditions with OR
+func Or(conds ...Cond) Cond { return junction{"OR", conds} }
+
+// not negates a condition
+type not struct {
+	cond Cond
+}
+
+func (n not) render(w *writer) {
+	w.sb.WriteString("NOT (")
+	n.cond.render(w)
+	w.sb.WriteByte(')')
+}
+
+// Not returns NOT (cond)
+func Not(cond Cond) Cond { return not{cond} }
+
+// expr is a raw SQL fragment using ? for its arguments
+type expr struct {
+	sql  string
+	args []interface{}
+}
+
+func (e expr) render(w *writer) {
+	// Parenthesize so the fragment keeps its meaning inside AND/OR
+	w.sb.WriteByte('(')
+	defer w.sb.WriteByte(')')
+
+	parts := strings.Split(e.sql, "?")
+	if len(parts)-1 != len(e.args) {
+		w.fail("expression %q has %d placeholders but %d arguments", e.sql, len(parts)-1, len(e.args))
+		return
+	}
+	for i, part := range parts {
+		w.sb.WriteString(part)
+		if i < len(e.args) {
+			w.arg(e.args[i])
+		}
+	}
+}
+
+// Expr returns a raw SQL condition. Each ? in sql is replaced with the
+// driver's placeholder for the corresponding argument; ToSQL fails when
+// the counts differ.
+func Expr(sql string, args ...interface{}) Cond { return expr{sql, args} }
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// BulkResult reports the outcome of a bulk insert
+type BulkResult struct {
+	Inserted int64
+	Skipped  int64
+}
+
+// BulkInsert inserts rows into table in a single transaction. On Postgres
+// the rows are streamed with COPY; other drivers use multi-row VALUES
+// statements chunked under the driver's parameter limit. Any conflicting
+// row fails the whole insert.
+func (dc *DatabaseConnection) BulkInsert(ctx context.Context, table string, columns []string, rows [][]interface{}) (BulkResult, error) {
+	return dc.bulkInTx(ctx, table, columns, nil, rows, false)
+}
+
+// BulkInsertIgnore inserts rows into table in a single transaction using
+// multi-row VALUES statements, skipping rows that conflict on
+// conflictColumns. Skipped rows are counted in the result.
+func (dc *DatabaseConnection) BulkInsertIgnore(ctx context.Context, table string, columns, conflictColumns []string, rows [][]interface{}) (BulkResult, error) {
+	return dc.bulkInTx(ctx, table, columns, conflictColumns, rows, true)
+}
+
+// BulkInsert inserts rows into table inside the transaction, like
+// DatabaseConnection.BulkInsert. A failure leaves the transaction for the
+// caller to roll back.
+func (tx *Tx) BulkInsert(ctx context.Context, table string, columns []string, rows [][]interface{}) (BulkResult, error) {
+	if len(rows) == 0 {
+		return BulkResult{}, nil
+	}
+	return tx.bulkInsert(ctx, table, columns, nil, rows, false)
+}
+
+// BulkInsertIgnore inserts rows into table inside the transaction,
+// skipping rows that conflict on conflictColumns, like
+// DatabaseConnection.BulkInsertIgnore
+func (tx *Tx) BulkInsertIgnore(ctx context.Context, table string, columns, conflictColumns []string, rows [][]interface{}) (BulkResult, error) {
+	if len(rows) == 0 {
+		return BulkResult{}, nil
+	}
+	return tx.bulkInsert(ctx, table, columns, conflictColumns, rows, true)
+}
+
+// bulkInTx runs bulkInsert inside a new transaction
+func (dc *DatabaseConnection) bulkInTx(ctx context.Context, table string, columns, conflictColumns []string, rows [][]interface{}, ignore bool) (BulkResult, error) {
+	if len(rows) == 0 {
+		return BulkResult{}, nil
+	}
+
+	tx, err := dc.BeginTx(ctx, nil)
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("failed to begin bulk insert transaction: %w", err)
+	}
+
+	result, err := tx.bulkInsert(ctx, table, columns, conflictColumns, rows, ignore)
+	if err != nil {
+		tx.Rollback()
+		dc.Logger.Error().
+			Err(err).
+			Str("table", table).
+			Int("rows", len(rows)).
+			Msg("Bulk insert failed")
+		return BulkResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return BulkResult{}, fmt.Errorf("failed to commit bulk insert: %w", err)
+	}
+
+	dc.Logger.Debug().
+		Str("table", table).
+		Int64("inserted", result.Inserted).
+		Int64("skipped", result.Skipped).
+		Msg("Bulk insert completed")
+
+	return result, nil
+}
+
+// bulkInsert inserts rows inside tx, choosing COPY or chunked VALUES
+func (tx *Tx) bulkInsert(ctx context.Context, table string, columns, conflictColumns []string, rows [][]interface{}, ignore bool) (BulkResult, error) {
+	if len(columns) == 0 {
+		return BulkResult{}, fmt.Errorf("bulk insert into %s: no columns given", table)
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return BulkResult{}, fmt.Errorf("bulk insert into %s: row %d has %d values, want %d",
+				table, i, len(row), len(columns))
+		}
+	}
+
+	if tx.dc.dialect.Name() == "postgres" && !ignore {
+		return tx.copyIn(ctx, table, columns, rows)
+	}
+	return tx.insertValues(ctx, table, columns, conflictColumns, rows, ignore)
+}
+
+// insertValues inserts rows with multi-row VALUES statements, each
+// holding as many rows as the dialect's parameter limit allows
+func (tx *Tx) insertValues(ctx context.Context, table string, columns, conflictColumns []string, rows [][]interface{}, ignore bool) (BulkResult, error) {
+	var result BulkResult
+	dialect := tx.dc.dialect
+
+	chunkSize := dialect.MaxParams() / len(columns)
+	if chunkSize < 1 {
+		return result, fmt.Errorf("bulk insert into %s: %d columns exceed the parameter limit", table, len(columns))
+	}
+
+	args := make([]interface{}, 0, chunkSize*len(columns))
+	for start := 0; start < len(rows); start += chunkSize {
+		end := start + chunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunk := rows[start:end]
+
+		var query string
+		if ignore {
+			query = dialect.InsertIgnore(table, columns, conflictColumns, len(chunk))
+		} else {
+			query = insertPrefix(dialect, "INSERT INTO", table, columns, len(chunk))
+		}
+
+		args = args[:0]
+		for _, row := range chunk {
+			args = append(args, row...)
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return result, fmt.Errorf("bulk insert into %s (rows %d-%d): %w", table, start, end-1, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			// Driver cannot report affected rows; assume the chunk went in
+			affected = int64(len(chunk))
+		}
+		result.Inserted += affected
+		result.Skipped += int64(len(chunk)) - affected
+	}
+
+	return result, nil
+}
+
+// copyIn streams rows into a Postgres table using COPY FROM STDIN. The
+// whole copy is reported to the hooks as a single exec without arguments.
+func (tx *Tx) copyIn(ctx context.Context, table string, columns []string, rows [][]interface{}) (BulkResult, error) {
+	var query string
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		query = pq.CopyInSchema(schema, name, columns...)
+	} else {
+		query = pq.CopyIn(table, columns...)
+	}
+
+	_, err := tx.dc.runExec(ctx, tx.event(OpExec, query, nil), func(ctx context.Context) (sql.Result, error) {
+		// COPY statements must not go through the statement cache
+		stmt, err := tx.Tx.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare COPY into %s: %w", table, err)
+		}
+		defer stmt.Close()
+
+		for i, row := range rows {
+			if _, err := stmt.ExecContext(ctx, row...); err != nil {
+				return nil, fmt.Errorf("COPY into %s (row %d): %w", table, i, err)
+			}
+		}
+
+		// An Exec without arguments flushes the buffered rows
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return nil, fmt.Errorf("COPY into %s: %w", table, err)
+		}
+
+		return driver.RowsAffected(len(rows)), nil
+	})
+	if err != nil {
+		return BulkResult{}, err
+	}
+
+	return BulkResult{Inserted: int64(len(rows))}, nil
+}
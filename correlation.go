@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// correlationKey stores the request ID and tags attached to a context
+type correlationKey struct{}
+
+// correlation identifies the request a statement is issued for
+type correlation struct {
+	requestID string
+	tags      map[string]string
+}
+
+// WithRequestID returns a copy of ctx carrying id. Statements issued with
+// the returned context are logged with a request_id field and, when
+// sql_comments is enabled, tagged with it in the SQL sent to the server.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	c := correlationFromContext(ctx)
+	c.requestID = id
+	return context.WithValue(ctx, correlationKey{}, c)
+}
+
+// WithTags returns a copy of ctx carrying tags, merged with any tags
+// already attached to ctx. Typical tags are the route, controller or job
+// name that issued the statement.
+func WithTags(ctx context.Context, tags map[string]string) context.Context {
+	c := correlationFromContext(ctx)
+	merged := make(map[string]string, len(c.tags)+len(tags))
+	for k, v := range c.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	c.tags = merged
+	return context.WithValue(ctx, correlationKey{}, c)
+}
+
+// RequestIDFromContext returns the request ID attached to ctx, if any
+func RequestIDFromContext(ctx context.Context) string {
+	return correlationFromContext(ctx).requestID
+}
+
+// correlationFromContext returns the correlation attached to ctx
+func correlationFromContext(ctx context.Context) correlation {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	return c
+}
+
+// contextLogger returns the connection's logger with the request ID, the
+// active trace and the tags from ctx added to every entry
+func (dc *DatabaseConnection) contextLogger(ctx context.Context) zerolog.Logger {
+	c := correlationFromContext(ctx)
+	span := trace.SpanContextFromContext(ctx)
+	if c.requestID == "" && len(c.tags) == 0 && !span.IsValid() {
+		return dc.Logger
+	}
+
+	logCtx := dc.Logger.With()
+	if c.requestID != "" {
+		logCtx = logCtx.Str("request_id", c.requestID)
+	}
+	if span.IsValid() {
+		logCtx = logCtx.
+			Str("trace_id", span.TraceID().String()).
+			Str("span_id", span.SpanID().String())
+	}
+	if len(c.tags) > 0 {
+		tags := zerolog.Dict()
+		for _, k := range sortedKeys(c.tags) {
+			tags = tags.Str(k, c.tags[k])
+		}
+		logCtx = logCtx.Dict("tags", tags)
+	}
+	return logCtx.Logger()
+}
+
+// annotate appends the sqlcommenter comment built from ctx to query when
+// sql_comments is enabled. Statements that already contain a comment are
+// left untouched, as the sqlcommenter specification requires. Statements
+// run through the statement cache are never annotated, so with
+// stmt_cache_size set only transaction statements carry the comment.
+func (dc *DatabaseConnection) annotate(ctx context.Context, query string) string {
+	if !dc.Config.Database.SQLComments {
+		return query
+	}
+	if strings.Contains(query, "/*") || strings.Contains(query, "--") {
+		return query
+	}
+
+	comment := sqlComment(ctx)
+	if comment == "" {
+		return query
+	}
+
+	// Keep a trailing semicolon after the comment
+	body := strings.TrimRight(query, "; \t\r\n")
+	return body + " " + comment + query[len(body):]
+}
+
+// sqlComment renders the request ID, the active trace and the tags from
+// ctx as a sqlcommenter comment: URL-encoded key='value' pairs sorted by
+// key. It returns "" when ctx carries none of them.
+func sqlComment(ctx context.Context) string {
+	c := correlationFromContext(ctx)
+	pairs := make(map[string]string, len(c.tags)+2)
+	for k, v := range c.tags {
+		pairs[k] = v
+	}
+	if c.requestID != "" {
+		pairs["request_id"] = c.requestID
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		pairs["traceparent"] = "00-" + span.TraceID().String() + "-" + span.SpanID().String() + "-" + span.TraceFlags().String()
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	fields := make([]string, 0, len(pairs))
+	for _, k := range sortedKeys(pairs) {
+		fields = append(fields, commentEscape(k)+"='"+commentEscape(pairs[k])+"'")
+	}
+	return "/*" + strings.Join(fields, ",") + "*/"
+}
+
+// commentEscape URL-encodes s for a sqlcommenter pair. Spaces become %20
+// as the specification requires. Quotes are replaced explicitly so a
+// value can never end its literal, whatever PathEscape leaves alone.
+func commentEscape(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "'", "%27")
+}
+
+// sortedKeys returns the keys of m in lexical order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
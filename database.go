@@ -1,15 +1,16 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 
 	// Database drivers
@@ -21,62 +22,204 @@ import (
 // Config represents the comprehensive database configuration
 type Config struct {
 	Database struct {
-		Driver     string `yaml:"driver"`
-		Host       string `yaml:"host"`
-		Port       int    `yaml:"port"`
-		Username   string `yaml:"username"`
-		Password   string `yaml:"password"`
-		DBName     string `yaml:"dbname"`
-		SSLMode    string `yaml:"sslmode"`
-		Filepath   string `yaml:"filepath"`
-		LogLevel   string `yaml:"log_level"`
-		DBSchema   string `yaml:"dbschema"`
-		Pool struct {
+		Driver             string `yaml:"driver"`
+		Host               string `yaml:"host"`
+		Port               int    `yaml:"port"`
+		Username           string `yaml:"username"`
+		Password           string `yaml:"password"`
+		DBName             string `yaml:"dbname"`
+		SSLMode            string `yaml:"sslmode"`
+		Filepath           string `yaml:"filepath"`
+		LogLevel           string `yaml:"log_level"`
+		DBSchema           string `yaml:"dbschema"`
+		StmtCacheSize      int    `yaml:"stmt_cache_size"`
+		SlowQueryThreshold string `yaml:"slow_query_threshold"`
+		ExplainSlowQueries bool   `yaml:"explain_slow_queries"`
+		Tracing            bool   `yaml:"tracing"`
+		SQLComments        bool   `yaml:"sql_comments"`
+		Pool               struct {
 			MaxOpenConns    int    `yaml:"max_open_conns"`
 			MaxIdleConns    int    `yaml:"max_idle_conns"`
 			ConnMaxLifetime string `yaml:"conn_max_lifetime"`
 			ConnMaxIdleTime string `yaml:"conn_max_idle_time"`
 		} `yaml:"pool"`
+		Redaction  RedactionConfig  `yaml:"redaction"`
+		Logger     LoggerConfig     `yaml:"logger"`
+		QueryStats QueryStatsConfig `yaml:"query_stats"`
+		Debug      DebugConfig      `yaml:"debug"`
+		Timeouts   TimeoutsConfig   `yaml:"timeouts"`
 	} `yaml:"database"`
 }
 
 // DatabaseConnection holds the database connection and configuration
 type DatabaseConnection struct {
-	DB     *sql.DB
-	Config *Config
-	Logger zerolog.Logger
+	DB       *sql.DB
+	Config   *Config
+	Logger   zerolog.Logger
+	dialect  Dialect
+	stmts    *stmtCache
+	redactor *redactor
+	hooks    []Hook
+
+	queryStats *queryStats
+	stopReport chan struct{}
+	logCloser  io.Closer
+	closeOnce  sync.Once
+	closeErr   error
+
+	recentSlow   *recentRing
+	recentErrors *recentRing
+	openRows     *rowsTracker
+	leakAfter    time.Duration
+
+	timeouts           timeouts
+	slowQueryThreshold time.Duration
+	explains           *explainLimiter
 }
 
 // NewDatabaseConnection creates a new database connection
 func NewDatabaseConnection(configPath string) (*DatabaseConnection, error) {
 	// Read configuration
-	config, err := readConfig(configPath)
+	config, err := ReadConfig(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config: %v", err)
+		return nil, fmt.Errorf("error reading config: %w", err)
 	}
 
+	return openConnection(context.Background(), config)
+}
+
+// openConnection connects to the database described by an already loaded
+// config, using the logger the config describes
+func openConnection(ctx context.Context, config *Config) (*DatabaseConnection, error) {
 	// Setup logger
-	logger := setupLogger(config.Database.LogLevel)
+	logger, logCloser, err := setupLogger(config)
+	if err != nil {
+		return nil, configError(fmt.Errorf("error setting up logger: %v", err))
+	}
+
+	dc, err := connect(ctx, config, logger)
+	if err != nil {
+		if logCloser != nil {
+			logCloser.Close()
+		}
+		return nil, err
+	}
+	dc.logCloser = logCloser
+
+	return dc, nil
+}
+
+// NewDatabaseConnectionWithLogger creates a new database connection that
+// writes to logger instead of the logger described by the config
+func NewDatabaseConnectionWithLogger(configPath string, logger zerolog.Logger) (*DatabaseConnection, error) {
+	// Read configuration
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+
+	return connect(context.Background(), config, logger)
+}
+
+// Connect connects to the database described by an already loaded
+// config, such as one read with ReadConfig and adjusted by the caller. It
+// writes to logger when not nil, otherwise to the logger the config
+// describes.
+func Connect(config *Config, logger *zerolog.Logger) (*DatabaseConnection, error) {
+	return ConnectContext(context.Background(), config, logger)
+}
+
+// ConnectContext is like Connect, but gives up on the initial ping when
+// ctx is done
+func ConnectContext(ctx context.Context, config *Config, logger *zerolog.Logger) (*DatabaseConnection, error) {
+	if logger != nil {
+		return connect(ctx, config, *logger)
+	}
+	return openConnection(ctx, config)
+}
+
+// connect opens and verifies the database described by config. The pool
+// is closed again on any failure after it is opened.
+func connect(ctx context.Context, config *Config, logger zerolog.Logger) (*DatabaseConnection, error) {
+	// Resolve driver dialect
+	dialect, err := LookupDialect(config.Database.Driver)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to resolve database dialect")
+		return nil, configError(err)
+	}
+
+	// Parse timeouts before building the connection string that uses them
+	timeouts, err := config.Database.Timeouts.parse()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to configure timeouts")
+		return nil, configError(err)
+	}
 
 	// Build connection string
-	dsn := buildConnectionString(config)
+	dsn := dialect.DSN(config)
 
 	// Open database connection
 	db, err := sql.Open(config.Database.Driver, dsn)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to open database connection")
-		return nil, err
+		return nil, configError(err)
 	}
 
 	// Configure connection pool
 	if err := configureConnectionPool(db, config); err != nil {
 		logger.Error().Err(err).Msg("Failed to configure connection pool")
-		return nil, err
+		db.Close()
+		return nil, configError(err)
+	}
+
+	// Compile argument redaction rules for logging
+	redactor, err := newRedactor(config.Database.Redaction)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to configure argument redaction")
+		db.Close()
+		return nil, configError(err)
+	}
+
+	// Parse slow query threshold; empty disables the slow query log
+	var slowQueryThreshold time.Duration
+	if config.Database.SlowQueryThreshold != "" {
+		slowQueryThreshold, err = time.ParseDuration(config.Database.SlowQueryThreshold)
+		if err != nil {
+			err = fmt.Errorf("invalid slow_query_threshold: %v", err)
+			logger.Error().Err(err).Msg("Failed to configure slow query log")
+			db.Close()
+			return nil, configError(err)
+		}
+	}
+
+	// Parse query statistics report interval; empty disables the report
+	var reportInterval time.Duration
+	if config.Database.QueryStats.ReportInterval != "" {
+		reportInterval, err = time.ParseDuration(config.Database.QueryStats.ReportInterval)
+		if err != nil {
+			err = fmt.Errorf("invalid query_stats report_interval: %v", err)
+			logger.Error().Err(err).Msg("Failed to configure query statistics")
+			db.Close()
+			return nil, configError(err)
+		}
+	}
+
+	// Parse leaked result set age for the debug page
+	leakAfter := time.Minute
+	if config.Database.Debug.LeakAfter != "" {
+		leakAfter, err = time.ParseDuration(config.Database.Debug.LeakAfter)
+		if err != nil {
+			err = fmt.Errorf("invalid debug leak_after: %v", err)
+			logger.Error().Err(err).Msg("Failed to configure debug handler")
+			db.Close()
+			return nil, configError(err)
+		}
 	}
 
 	// Ping database to verify connection
-	if err := pingDatabase(db, logger); err != nil {
+	if err := pingDatabase(ctx, db, timeouts.ping); err != nil {
 		logger.Error().Err(err).Msg("Database connection ping failed")
+		db.Close()
 		return nil, err
 	}
 
@@ -86,63 +229,66 @@ func NewDatabaseConnection(configPath string) (*DatabaseConnection, error) {
 		Str("database", config.Database.DBName).
 		Msg("Database connection established successfully")
 
-	return &DatabaseConnection{
-		DB:     db,
-		Config: config,
-		Logger: logger,
-	}, nil
+	dc := &DatabaseConnection{
+		DB:       db,
+		Config:   config,
+		Logger:   logger,
+		dialect:  dialect,
+		redactor: redactor,
+
+		queryStats: newQueryStats(config.Database.QueryStats.MaxFingerprints),
+
+		recentSlow:   newRecentRing(config.Database.Debug.RecentSize),
+		recentErrors: newRecentRing(config.Database.Debug.RecentSize),
+		leakAfter:    leakAfter,
+
+		timeouts:           timeouts,
+		slowQueryThreshold: slowQueryThreshold,
+		explains:           newExplainLimiter(explainConcurrency, explainInterval),
+	}
+	if config.Database.StmtCacheSize > 0 {
+		dc.stmts = newStmtCache(config.Database.StmtCacheSize)
+	}
+	if config.Database.Debug.TrackRows {
+		dc.openRows = newRowsTracker()
+	}
+	dc.AddHook(loggingHook{dc: dc})
+	dc.AddHook(statsHook{stats: dc.queryStats})
+	dc.AddHook(recentHook{dc: dc})
+	if config.Database.Tracing {
+		dc.EnableTracing(nil)
+	}
+	if reportInterval > 0 {
+		dc.startStatsReport(reportInterval, config.Database.QueryStats.TopN)
+	}
+
+	return dc, nil
 }
 
-// readConfig reads the YAML configuration file
-func readConfig(configPath string) (*Config, error) {
+// ReadConfig reads the YAML configuration file
+func ReadConfig(configPath string) (*Config, error) {
 	// Ensure absolute path
 	absPath, err := filepath.Abs(configPath)
 	if err != nil {
-		return nil, err
+		return nil, configError(err)
 	}
 
 	// Read file
 	data, err := os.ReadFile(absPath)
 	if err != nil {
-		return nil, err
-	}
-
-	// Unmarshal YAML
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return nil, err
+		return nil, configError(err)
 	}
 
-	return &config, nil
+	return parseConfig(data)
 }
 
-// buildConnectionString creates connection string based on driver
-func buildConnectionString(config *Config) string {
-	switch config.Database.Driver {
-	case "postgres":
-		return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s search_path=%s",
-			config.Database.Host,
-			config.Database.Port,
-			config.Database.Username,
-			config.Database.Password,
-			config.Database.DBName,
-			config.Database.SSLMode,
-			config.Database.DBSchema,
-		)
-	case "mysql":
-		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			config.Database.Username,
-			config.Database.Password,
-			config.Database.Host,
-			config.Database.Port,
-			config.Database.DBName,
-		)
-	case "sqlite3":
-		return config.Database.Filepath
-	default:
-		panic(fmt.Sprintf("Unsupported database driver: %s", config.Database.Driver))
+// parseConfig unmarshals the YAML configuration in data
+func parseConfig(data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, configError(err)
 	}
+	return &config, nil
 }
 
 // configureConnectionPool sets up connection pool settings
@@ -167,97 +313,131 @@ func configureConnectionPool(db *sql.DB, config *Config) error {
 	return nil
 }
 
-// pingDatabase tests the database connection
-func pingDatabase(db *sql.DB, logger zerolog.Logger) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// pingDatabase tests the database connection, giving up when ctx is done
+// or after timeout unless it is zero
+func pingDatabase(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	if err := db.PingContext(ctx); err != nil {
-		return fmt.Errorf("database ping failed: %v", err)
+		return fmt.Errorf("database ping failed: %w", wrapError(err))
 	}
 
 	return nil
 }
 
-// setupLogger configures zerolog based on log level
-func setupLogger(level string) zerolog.Logger {
-	// Configure log level
-	switch level {
-	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
-	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
-	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	}
+// Dialect returns the SQL dialect of the configured driver
+func (dc *DatabaseConnection) Dialect() Dialect {
+	return dc.dialect
+}
 
-	// Create logger with timestamp
-	return zerolog.New(os.Stdout).With().Timestamp().Logger()
+// Stats holds runtime statistics for a DatabaseConnection
+type Stats struct {
+	Pool      sql.DBStats        `json:"pool"`
+	StmtCache *StmtCacheStats    `json:"stmt_cache,omitempty"`
+	Queries   []FingerprintStats `json:"queries"`
+}
+
+// Stats returns connection pool, statement cache and per-fingerprint
+// query statistics. Queries are ordered by total time, highest first.
+func (dc *DatabaseConnection) Stats() Stats {
+	stats := Stats{Pool: dc.DB.Stats(), Queries: dc.queryStats.snapshot()}
+	if dc.stmts != nil {
+		cache := dc.stmts.snapshot()
+		stats.StmtCache = &cache
+	}
+	return stats
 }
 
-// Close closes the database connection
+// Close closes the database connection. Later calls do nothing and
+// return the error of the first.
 func (dc *DatabaseConnection) Close() error {
-	dc.Logger.Info().Msg("Closing database connection")
-	return dc.DB.Close()
+	dc.closeOnce.Do(func() {
+		dc.Logger.Info().Msg("Closing database connection")
+		if dc.stopReport != nil {
+			close(dc.stopReport)
+		}
+		if dc.stmts != nil {
+			dc.stmts.purge()
+		}
+		dc.closeErr = dc.DB.Close()
+		if dc.logCloser != nil {
+			dc.logCloser.Close()
+		}
+	})
+	return dc.closeErr
 }
 
 // Query executes a generic query with logging
 func (dc *DatabaseConnection) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	// Log the query with debug level
-	dc.Logger.Debug().
-		Str("query", query).
-		Interface("args", args).
-		Msg("Executing database query")
-
-	// Execute the query
-	rows, err := dc.DB.Query(query, args...)
-	if err != nil {
-		dc.Logger.Error().
-			Err(err).
-			Str("query", query).
-			Interface("args", args).
-			Msg("Query execution failed")
-		return nil, err
-	}
+	return dc.QueryContext(context.Background(), query, args...)
+}
 
-	return rows, nil
+// QueryContext executes a generic query through the hook chain
+func (dc *DatabaseConnection) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return dc.runQuery(ctx, newEvent(OpQuery, query, args), func(ctx context.Context) (*sql.Rows, error) {
+		return dc.queryDB(ctx, query, args...)
+	})
 }
 
 // QueryRow executes a query that is expected to return at most one row
 func (dc *DatabaseConnection) QueryRow(query string, args ...interface{}) *sql.Row {
-	// Log the query with debug level
-	dc.Logger.Debug().
-		Str("query", query).
-		Interface("args", args).
-		Msg("Executing single row query")
+	return dc.QueryRowContext(context.Background(), query, args...)
+}
 
-	return dc.DB.QueryRow(query, args...)
+// QueryRowContext executes a single-row query through the hook chain
+func (dc *DatabaseConnection) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return dc.runQueryRow(ctx, newEvent(OpQueryRow, query, args), func(ctx context.Context) *sql.Row {
+		return dc.queryRowDB(ctx, query, args...)
+	})
 }
 
 // Exec executes a query without returning any rows
 func (dc *DatabaseConnection) Exec(query string, args ...interface{}) (sql.Result, error) {
-	// Log the query with debug level
-	dc.Logger.Debug().
-		Str("query", query).
-		Interface("args", args).
-		Msg("Executing database modification")
-
-	// Execute the query
-	result, err := dc.DB.Exec(query, args...)
+	return dc.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext executes a modification through the hook chain
+func (dc *DatabaseConnection) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return dc.runExec(ctx, newEvent(OpExec, query, args), func(ctx context.Context) (sql.Result, error) {
+		return dc.execDB(ctx, query, args...)
+	})
+}
+
+// SQLBuilder is implemented by statements that render their own SQL and
+// arguments, such as those from the builder package
+type SQLBuilder interface {
+	ToSQL() (string, []interface{}, error)
+}
+
+// QueryFrom renders b and executes it with Query
+func (dc *DatabaseConnection) QueryFrom(b SQLBuilder) (*sql.Rows, error) {
+	query, args, err := b.ToSQL()
 	if err != nil {
-		dc.Logger.Error().
-			Err(err).
-			Str("query", query).
-			Interface("args", args).
-			Msg("Query execution failed")
 		return nil, err
 	}
+	return dc.Query(query, args...)
+}
 
-	return result, nil
+// QueryRowFrom renders b and executes it with QueryRow
+func (dc *DatabaseConnection) QueryRowFrom(b SQLBuilder) (*sql.Row, error) {
+	query, args, err := b.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	return dc.QueryRow(query, args...), nil
+}
+
+// ExecFrom renders b and executes it with Exec
+func (dc *DatabaseConnection) ExecFrom(b SQLBuilder) (sql.Result, error) {
+	query, args, err := b.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	return dc.Exec(query, args...)
 }
 #########################################################
 
@@ -0,0 +1,321 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// debugTimeout bounds the health check and version query behind each
+// debug page request
+const debugTimeout = 2 * time.Second
+
+// DebugConfig controls the in-memory history served by DebugHandler
+type DebugConfig struct {
+	RecentSize int    `yaml:"recent_size"`
+	TrackRows  bool   `yaml:"track_rows"`
+	LeakAfter  string `yaml:"leak_after"`
+}
+
+// RecentQuery is a slow or failed call kept for the debug page. Query is
+// normalized so literal values are never exposed.
+type RecentQuery struct {
+	Time        time.Time     `json:"time"`
+	Op          string        `json:"op"`
+	Fingerprint string        `json:"fingerprint,omitempty"`
+	Query       string        `json:"query,omitempty"`
+	Duration    time.Duration `json:"duration"`
+	InTx        bool          `json:"in_tx,omitempty"`
+	RequestID   string        `json:"request_id,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// OpenRows describes a result set that hasn't been closed
+type OpenRows struct {
+	Query     string        `json:"query"`
+	Caller    string        `json:"caller"`
+	Location  string        `json:"location"`
+	RequestID string        `json:"request_id,omitempty"`
+	Opened    time.Time     `json:"opened"`
+	Age       time.Duration `json:"age"`
+}
+
+// HealthStatus is the outcome of a ping
+type HealthStatus struct {
+	Status  string        `json:"status"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// DebugStatus is the document served by DebugHandler
+type DebugStatus struct {
+	Driver        string                 `json:"driver"`
+	ServerVersion string                 `json:"server_version,omitempty"`
+	Health        HealthStatus           `json:"health"`
+	Config        map[string]interface{} `json:"config"`
+	Stats         Stats                  `json:"stats"`
+	SlowQueries   []RecentQuery          `json:"slow_queries"`
+	Errors        []RecentQuery          `json:"errors"`
+	LeakedRows    []OpenRows             `json:"leaked_rows,omitempty"`
+}
+
+// DebugHandler returns an http.Handler serving DebugStatus as JSON. It
+// exposes query shapes and configuration, so mount it on an internal
+// admin port only.
+func (dc *DatabaseConnection) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status, err := dc.DebugStatus(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(status); err != nil {
+			dc.Logger.Error().Err(err).Msg("Failed to write debug status")
+		}
+	})
+}
+
+// DebugStatus collects the connection's current state. The health check
+// and server version query run directly on the pool, bypassing hooks, so
+// polling the page doesn't show up in logs or query statistics.
+func (dc *DatabaseConnection) DebugStatus(ctx context.Context) (DebugStatus, error) {
+	config, err := dc.redactedConfig()
+	if err != nil {
+		return DebugStatus{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, debugTimeout)
+	defer cancel()
+
+	status := DebugStatus{
+		Driver:      dc.dialect.Name(),
+		Health:      dc.health(ctx),
+		Config:      config,
+		Stats:       dc.Stats(),
+		SlowQueries: dc.recentSlow.snapshot(),
+		Errors:      dc.recentErrors.snapshot(),
+	}
+	if status.Health.Status == "up" {
+		dc.DB.QueryRowContext(ctx, dc.dialect.ServerInfoQueries().Version).Scan(&status.ServerVersion)
+	}
+	if dc.openRows != nil {
+		status.LeakedRows = dc.openRows.leaked(dc.leakAfter)
+	}
+	return status, nil
+}
+
+// health pings the database and reports the outcome
+func (dc *DatabaseConnection) health(ctx context.Context) HealthStatus {
+	start := time.Now()
+	err := dc.DB.PingContext(ctx)
+	health := HealthStatus{Status: "up", Latency: time.Since(start)}
+	if err != nil {
+		health.Status = "down"
+		health.Error = err.Error()
+	}
+	return health
+}
+
+// redactedConfig returns the effective configuration keyed by its YAML
+// names, with the password masked
+func (dc *DatabaseConnection) redactedConfig() (map[string]interface{}, error) {
+	config := *dc.Config
+	if config.Database.Password != "" {
+		config.Database.Password = redactedValue
+	}
+
+	data, err := yaml.Marshal(&config)
+	if err != nil {
+		return nil, err
+	}
+	var redacted map[string]interface{}
+	if err := yaml.Unmarshal(data, &redacted); err != nil {
+		return nil, err
+	}
+	return redacted, nil
+}
+
+// recentRing keeps the most recent calls, overwriting the oldest
+type recentRing struct {
+	mu      sync.Mutex
+	entries []RecentQuery
+	next    int
+	full    bool
+}
+
+// newRecentRing creates a ring holding size entries, 50 if size <= 0
+func newRecentRing(size int) *recentRing {
+	if size <= 0 {
+		size = 50
+	}
+	return &recentRing{entries: make([]RecentQuery, size)}
+}
+
+// add stores q, evicting the oldest entry when the ring is full
+func (r *recentRing) add(q RecentQuery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = q
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns the stored entries, newest first
+func (r *recentRing) snapshot() []RecentQuery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+	recent := make([]RecentQuery, 0, n)
+	for i := 1; i <= n; i++ {
+		recent = append(recent, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return recent
+}
+
+// recentHook records slow and failed calls for the debug page
+type recentHook struct {
+	dc *DatabaseConnection
+}
+
+// Before is a no-op; calls are recorded once they complete
+func (h recentHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+// After records the call if it failed or exceeded slow_query_threshold
+func (h recentHook) After(ctx context.Context, event *QueryEvent) {
+	slow := false
+	switch event.Op {
+	case OpQuery, OpQueryRow, OpExec:
+		slow = h.dc.slowQueryThreshold > 0 && event.Duration >= h.dc.slowQueryThreshold
+	}
+	if !slow && event.Err == nil {
+		return
+	}
+
+	q := RecentQuery{
+		Time:      event.Start,
+		Op:        event.Op,
+		Duration:  event.Duration,
+		InTx:      event.InTx,
+		RequestID: RequestIDFromContext(ctx),
+	}
+	if event.Query != "" {
+		q.Query = NormalizeQuery(event.Query)
+		q.Fingerprint = hashNormalized(q.Query)
+	}
+
+	if slow {
+		h.dc.recentSlow.add(q)
+	}
+	if event.Err != nil {
+		q.Error = event.Err.Error()
+		h.dc.recentErrors.add(q)
+	}
+}
+
+// trackedRows is an open result set and where it was opened
+type trackedRows struct {
+	query     string
+	caller    string
+	location  string
+	requestID string
+	opened    time.Time
+}
+
+// rowsTracker remembers the result sets returned by Query until they are
+// closed, so ones that are never closed can be reported
+type rowsTracker struct {
+	mu        sync.Mutex
+	open      map[*sql.Rows]trackedRows
+	sweepSize int
+}
+
+// newRowsTracker creates an empty tracker
+func newRowsTracker() *rowsTracker {
+	return &rowsTracker{open: make(map[*sql.Rows]trackedRows), sweepSize: 64}
+}
+
+// add starts tracking rows. Closed result sets are swept whenever the
+// tracker doubles in size, keeping the cost per query constant.
+func (t *rowsTracker) add(rows *sql.Rows, query, requestID string) {
+	function, location := queryCaller()
+	entry := trackedRows{
+		query:     NormalizeQuery(query),
+		caller:    function,
+		location:  location,
+		requestID: requestID,
+		opened:    time.Now(),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.open[rows] = entry
+	if len(t.open) >= t.sweepSize {
+		t.sweepLocked()
+		if len(t.open)*2 > t.sweepSize {
+			t.sweepSize *= 2
+		}
+	}
+}
+
+// sweepLocked forgets result sets that have been closed, either
+// explicitly or by reading them to the end
+func (t *rowsTracker) sweepLocked() {
+	for rows := range t.open {
+		// Columns only fails once the result set is closed
+		if _, err := rows.Columns(); err != nil {
+			delete(t.open, rows)
+		}
+	}
+}
+
+// leaked returns the result sets open for longer than age, oldest first
+func (t *rowsTracker) leaked(age time.Duration) []OpenRows {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweepLocked()
+	now := time.Now()
+	var leaked []OpenRows
+	for _, entry := range t.open {
+		if now.Sub(entry.opened) < age {
+			continue
+		}
+		leaked = append(leaked, OpenRows{
+			Query:     entry.query,
+			Caller:    entry.caller,
+			Location:  entry.location,
+			RequestID: entry.requestID,
+			Opened:    entry.opened,
+			Age:       now.Sub(entry.opened),
+		})
+	}
+
+	sort.Slice(leaked, func(i, j int) bool { return leaked[i].Opened.Before(leaked[j].Opened) })
+	return leaked
+}
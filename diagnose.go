@@ -0,0 +1,398 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diagnoseTimeout bounds each diagnostic step when the config disables
+// the connect timeout
+const diagnoseTimeout = 5 * time.Second
+
+// postgresSSLRequest is the protocol code a Postgres client sends to ask
+// for TLS before the startup message
+const postgresSSLRequest = 80877103
+
+// Diagnostic step outcomes
+const (
+	StepPass = "pass"
+	StepWarn = "warn"
+	StepFail = "fail"
+	StepSkip = "skip"
+)
+
+// DiagnosticStep is the outcome of one layer of a connection diagnosis
+type DiagnosticStep struct {
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	Duration   time.Duration `json:"duration"`
+	Detail     string        `json:"detail,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+	Hint       string        `json:"hint,omitempty"`
+}
+
+// stepError is a failed or questionable step with advice for the user
+type stepError struct {
+	err   error
+	class string
+	hint  string
+	warn  bool
+}
+
+func (e *stepError) Error() string { return e.err.Error() }
+
+func (e *stepError) Unwrap() error { return e.err }
+
+// failStep fails a step with the given failure class and hint
+func failStep(err error, class, hint string) error {
+	return &stepError{err: err, class: class, hint: hint}
+}
+
+// warnStep flags a step that passed but likely points at a problem
+func warnStep(err error, hint string) error {
+	return &stepError{err: err, hint: hint, warn: true}
+}
+
+// diagnosis runs steps in order, skipping the rest after a failure
+type diagnosis struct {
+	steps   []DiagnosticStep
+	failed  bool
+	timeout time.Duration
+}
+
+// run executes fn as the step called name
+func (d *diagnosis) run(ctx context.Context, name string, fn func(ctx context.Context) (string, error)) {
+	step := DiagnosticStep{Name: name}
+	if d.failed {
+		step.Status = StepSkip
+		step.Detail = "skipped after an earlier failure"
+		d.steps = append(d.steps, step)
+		return
+	}
+
+	timeout := d.timeout
+	if timeout <= 0 {
+		timeout = diagnoseTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := fn(ctx)
+	step.Duration = time.Since(start)
+	step.Detail = detail
+	step.Status = StepPass
+
+	if err != nil {
+		step.Error = err.Error()
+		step.Status = StepFail
+
+		var se *stepError
+		if errors.As(err, &se) {
+			step.Hint = se.hint
+			step.ErrorClass = se.class
+			if se.warn {
+				step.Status = StepWarn
+			}
+		}
+		if step.Status == StepFail {
+			if step.ErrorClass == "" {
+				step.ErrorClass = FailureClass(err)
+			}
+			d.failed = true
+		}
+	}
+	d.steps = append(d.steps, step)
+}
+
+// skip records a step that doesn't apply to the configuration
+func (d *diagnosis) skip(name, reason string) {
+	d.steps = append(d.steps, DiagnosticStep{Name: name, Status: StepSkip, Detail: reason})
+}
+
+// Diagnose checks the connection described by the config file one layer
+// at a time: config parsing, DNS, TCP, TLS, authentication, a trivial
+// query and schema access, or for SQLite the database file and its
+// permissions. Steps after the first failure are skipped. Non-empty
+// overrides replace the config's timeouts; each later step is bounded by
+// the connect timeout.
+func Diagnose(ctx context.Context, configPath string, overrides TimeoutsConfig) []DiagnosticStep {
+	var d diagnosis
+	var config *Config
+	var dialect Dialect
+
+	d.run(ctx, "config", func(ctx context.Context) (string, error) {
+		var err error
+		config, err = ReadConfig(configPath)
+		if err != nil {
+			return "", failStep(err, FailureConfig, "check that the file exists and is valid YAML with a top-level database: key")
+		}
+		dialect, err = LookupDialect(config.Database.Driver)
+		if err != nil {
+			return "", failStep(err, FailureConfig, "set driver to postgres, mysql or sqlite3")
+		}
+		config.Database.Timeouts = config.Database.Timeouts.Override(overrides)
+		timeouts, err := config.Database.Timeouts.parse()
+		if err != nil {
+			return "", failStep(err, FailureConfig, "use Go durations such as 10s or 1m in the timeouts section")
+		}
+		d.timeout = timeouts.connect
+		return "driver " + config.Database.Driver, nil
+	})
+	if d.failed {
+		return d.steps
+	}
+
+	// SQLite has no authentication; the step just opens the file
+	connectStep := "auth"
+	if config.Database.Driver == "sqlite3" {
+		connectStep = "open"
+		if !d.diagnoseSQLiteFile(ctx, config) {
+			// Opening would create the file as a side effect
+			for _, name := range []string{connectStep, "select", "schema"} {
+				d.skip(name, "database file does not exist")
+			}
+			return d.steps
+		}
+	} else {
+		d.diagnoseNetwork(ctx, config)
+	}
+
+	var db *sql.DB
+	d.run(ctx, connectStep, func(ctx context.Context) (string, error) {
+		var err error
+		db, err = sql.Open(config.Database.Driver, dialect.DSN(config))
+		if err != nil {
+			return "", failStep(err, FailureConfig, "check the driver settings in the config")
+		}
+		db.SetMaxOpenConns(1)
+		if err := db.PingContext(ctx); err != nil {
+			err = wrapError(err)
+			if errors.Is(err, ErrAuth) {
+				return "", failStep(err, FailureAuth, "check username and password, and that the server allows this user to connect from this host")
+			}
+			return "", err
+		}
+		if config.Database.Username == "" {
+			return "connected", nil
+		}
+		return "connected as " + config.Database.Username, nil
+	})
+	if db != nil {
+		defer db.Close()
+	}
+
+	d.run(ctx, "select", func(ctx context.Context) (string, error) {
+		var one int
+		if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+			return "", wrapError(err)
+		}
+		return "SELECT 1 returned a row", nil
+	})
+
+	schema := config.Database.DBSchema
+	if schema == "" && config.Database.Driver == "mysql" {
+		schema = config.Database.DBName
+	}
+	query := dialect.ServerInfoQueries().SchemaAccess
+	if query == "" {
+		// dbschema defaults to public, which would never match here
+		d.skip("schema", config.Database.Driver+" has no schema permissions to check")
+		return d.steps
+	}
+	if schema == "" {
+		d.skip("schema", "no dbschema configured")
+		return d.steps
+	}
+	d.run(ctx, "schema", func(ctx context.Context) (string, error) {
+		var usable bool
+		err := db.QueryRowContext(ctx, query, schema).Scan(&usable)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", failStep(fmt.Errorf("schema %q does not exist or is not visible", schema), "",
+				"create the schema or fix dbschema in the config")
+		case err != nil:
+			return "", wrapError(err)
+		case !usable:
+			return "", failStep(fmt.Errorf("no access to schema %q", schema), "",
+				"grant the user access, e.g. GRANT USAGE ON SCHEMA "+schema+" TO "+config.Database.Username)
+		}
+		return "schema " + schema + " is accessible", nil
+	})
+
+	return d.steps
+}
+
+// diagnoseNetwork checks name resolution, TCP reachability and, when the
+// config asks for it, the TLS handshake
+func (d *diagnosis) diagnoseNetwork(ctx context.Context, config *Config) {
+	host := config.Database.Host
+	addr := net.JoinHostPort(host, strconv.Itoa(config.Database.Port))
+
+	d.run(ctx, "dns", func(ctx context.Context) (string, error) {
+		if host == "" {
+			return "", failStep(errors.New("host is empty"), FailureConfig, "set host in the config")
+		}
+		if net.ParseIP(host) != nil {
+			return host + " is an IP address", nil
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return "", failStep(err, FailureDNS, "check the host name and the resolver configuration (/etc/resolv.conf)")
+		}
+		return host + " resolves to " + strings.Join(addrs, ", "), nil
+	})
+
+	d.run(ctx, "tcp", func(ctx context.Context) (string, error) {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			switch class := FailureClass(wrapError(err)); class {
+			case FailureRefused:
+				return "", failStep(err, class, "nothing is listening on "+addr+"; check the port and that the server is running")
+			case FailureTimeout:
+				return "", failStep(err, class, "the connection attempt got no answer; a firewall may be dropping traffic to "+addr)
+			}
+			return "", err
+		}
+		conn.Close()
+		return "connected to " + addr, nil
+	})
+
+	if config.Database.Driver != "postgres" {
+		d.skip("tls", "TLS is not configured for "+config.Database.Driver)
+		return
+	}
+	sslMode := config.Database.SSLMode
+	if sslMode == "disable" {
+		d.skip("tls", "sslmode is disable")
+		return
+	}
+	d.run(ctx, "tls", func(ctx context.Context) (string, error) {
+		return postgresTLSHandshake(ctx, addr, host, sslMode)
+	})
+}
+
+// postgresTLSHandshake negotiates TLS the way lib/pq does for sslmode and
+// describes the resulting session
+func postgresTLSHandshake(ctx context.Context, addr, host, sslMode string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], postgresSSLRequest)
+	if _, err := conn.Write(request); err != nil {
+		return "", err
+	}
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return "", err
+	}
+	if answer[0] != 'S' {
+		return "", failStep(errors.New("server does not accept TLS connections"), FailureTLS,
+			"enable ssl on the server or set sslmode: disable")
+	}
+
+	// Like lib/pq, require encrypts without verifying the certificate and
+	// verify-ca checks the chain but not the host name
+	tlsConfig := &tls.Config{ServerName: host}
+	switch sslMode {
+	case "", "require":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = verifyChain
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return "", failStep(err, FailureTLS, "check that the server certificate is issued for "+host+" by a CA this host trusts, or relax sslmode")
+	}
+
+	state := tlsConn.ConnectionState()
+	return fmt.Sprintf("%s, %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)), nil
+}
+
+// verifyChain checks the server certificate chain against the system
+// roots without checking the host name
+func verifyChain(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server sent no certificate")
+	}
+	opts := x509.VerifyOptions{Intermediates: x509.NewCertPool()}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// diagnoseSQLiteFile checks that the database file exists and that both
+// the file and its directory, where SQLite keeps its journal, are
+// writable. It reports whether the file exists.
+func (d *diagnosis) diagnoseSQLiteFile(ctx context.Context, config *Config) bool {
+	path := config.Database.Filepath
+	exists := false
+
+	d.run(ctx, "file", func(ctx context.Context) (string, error) {
+		if path == "" {
+			return "", failStep(errors.New("filepath is empty"), FailureConfig, "set filepath in the config")
+		}
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if _, dirErr := os.Stat(filepath.Dir(path)); dirErr != nil {
+				return "", failStep(dirErr, FailureConfig, "the directory of filepath doesn't exist")
+			}
+			return "", warnStep(fmt.Errorf("%s does not exist", path),
+				"SQLite will create an empty database here; check filepath if you expected existing data")
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			return "", failStep(fmt.Errorf("%s is a directory", path), FailureConfig, "point filepath at the database file")
+		}
+		exists = true
+		return fmt.Sprintf("%s, %d bytes", path, info.Size()), nil
+	})
+
+	d.run(ctx, "write", func(ctx context.Context) (string, error) {
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Close()
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", failStep(err, "", "make the database file writable by this user")
+		}
+
+		dir := filepath.Dir(path)
+		probe, err := os.CreateTemp(dir, ".diagnose-*")
+		if err != nil {
+			return "", failStep(err, "", "SQLite creates journal files next to the database; make "+dir+" writable by this user")
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+		return "file and directory are writable", nil
+	})
+
+	return exists
+}
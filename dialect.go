@@ -0,0 +1,390 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect describes the SQL differences between the supported drivers so
+// application code can build statements that run on any of them
+type Dialect interface {
+	// Name returns the database/sql driver name the dialect belongs to
+	Name() string
+
+	// DSN builds the driver-specific connection string from the config
+	DSN(config *Config) string
+
+	// QuoteIdent quotes a (possibly schema-qualified) identifier
+	QuoteIdent(name string) string
+
+	// Placeholder returns the bind parameter for the n-th argument (1-based)
+	Placeholder(n int) string
+
+	// MaxParams returns the maximum number of bind parameters per statement
+	MaxParams() int
+
+	// InsertIgnore returns an INSERT of rows value tuples that silently skips
+	// rows conflicting on conflictColumns
+	InsertIgnore(table string, columns, conflictColumns []string, rows int) string
+
+	// Upsert returns an INSERT of rows value tuples that updates
+	// updateColumns when a row conflicts on conflictColumns
+	Upsert(table string, columns, conflictColumns, updateColumns []string, rows int) string
+
+	// LimitOffset returns the LIMIT/OFFSET clause; values <= 0 are omitted
+	LimitOffset(limit, offset int) string
+
+	// BoolLiteral returns the SQL literal for a boolean value
+	BoolLiteral(b bool) string
+
+	// TimeLiteral returns the SQL literal for a timestamp value
+	TimeLiteral(t time.Time) string
+
+	// SupportsReturning reports whether INSERT/UPDATE/DELETE ... RETURNING works
+	SupportsReturning() bool
+
+	// Returning returns the RETURNING clause, or "" when unsupported
+	Returning(columns []string) string
+
+	// ServerInfoQueries returns the queries describing the server and the
+	// current session
+	ServerInfoQueries() ServerInfoQueries
+}
+
+// ServerInfoQueries holds one query per server property. Each selects a
+// single value; an empty query means the driver has no equivalent.
+type ServerInfoQueries struct {
+	Version  string
+	User     string
+	Database string
+	Schema   string
+	TLS      string
+	ReadOnly string
+	Replica  string
+
+	// SchemaAccess selects whether the current user can use the schema
+	// named by the first bind parameter; no row means it doesn't exist
+	SchemaAccess string
+}
+
+// dialects holds the registered dialects keyed by driver name
+var dialects = map[string]Dialect{}
+
+func init() {
+	RegisterDialect(postgresDialect{})
+	RegisterDialect(mysqlDialect{})
+	RegisterDialect(sqliteDialect{})
+}
+
+// RegisterDialect makes a dialect available for its driver name, replacing
+// any dialect previously registered under that name
+func RegisterDialect(d Dialect) {
+	dialects[d.Name()] = d
+}
+
+// LookupDialect returns the dialect registered for the given driver
+func LookupDialect(driver string) (Dialect, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+	return d, nil
+}
+
+// quoteIdentWith quotes each dot-separated part of name with q, doubling
+// any embedded quote characters
+func quoteIdentWith(name, q string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = q + strings.ReplaceAll(part, q, q+q) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteIdents quotes every identifier in names
+func quoteIdents(d Dialect, names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = d.QuoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// valuesList returns rows placeholder tuples of width columns, numbered
+// consecutively from the first argument
+func valuesList(d Dialect, columns, rows int) string {
+	var sb strings.Builder
+	n := 1
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteByte('(')
+		for c := 0; c < columns; c++ {
+			if c > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(d.Placeholder(n))
+			n++
+		}
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+// insertPrefix returns "INSERT INTO table (columns) VALUES (...), ..."
+func insertPrefix(d Dialect, verb, table string, columns []string, rows int) string {
+	return fmt.Sprintf("%s %s (%s) VALUES %s",
+		verb, d.QuoteIdent(table), quoteIdents(d, columns), valuesList(d, len(columns), rows))
+}
+
+// onConflict returns the Postgres/SQLite ON CONFLICT clause; excluded is
+// the name of the pseudo-table holding the proposed row
+func onConflict(d Dialect, conflictColumns, updateColumns []string, excluded string) string {
+	var target string
+	if len(conflictColumns) > 0 {
+		target = " (" + quoteIdents(d, conflictColumns) + ")"
+	}
+	if len(updateColumns) == 0 {
+		return " ON CONFLICT" + target + " DO NOTHING"
+	}
+	sets := make([]string, len(updateColumns))
+	for i, col := range updateColumns {
+		sets[i] = fmt.Sprintf("%s = %s.%s", d.QuoteIdent(col), excluded, d.QuoteIdent(col))
+	}
+	return " ON CONFLICT" + target + " DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// limitOffset renders LIMIT/OFFSET; noLimit is used when only an offset is set
+func limitOffset(limit, offset int, noLimit string) string {
+	switch {
+	case limit > 0 && offset > 0:
+		return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	case limit > 0:
+		return fmt.Sprintf(" LIMIT %d", limit)
+	case offset > 0 && noLimit != "":
+		return fmt.Sprintf(" LIMIT %s OFFSET %d", noLimit, offset)
+	case offset > 0:
+		return fmt.Sprintf(" OFFSET %d", offset)
+	default:
+		return ""
+	}
+}
+
+// postgresDialect implements Dialect for lib/pq
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) DSN(config *Config) string {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s search_path=%s",
+		config.Database.Host,
+		config.Database.Port,
+		config.Database.Username,
+		config.Database.Password,
+		config.Database.DBName,
+		config.Database.SSLMode,
+		config.Database.DBSchema,
+	)
+	// connect_timeout takes whole seconds
+	if timeout := connectTimeout(config); timeout > 0 {
+		dsn += fmt.Sprintf(" connect_timeout=%d", int((timeout+time.Second-1)/time.Second))
+	}
+	return dsn
+}
+
+func (postgresDialect) QuoteIdent(name string) string { return quoteIdentWith(name, `"`) }
+
+func (postgresDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (postgresDialect) MaxParams() int { return 65535 }
+
+func (d postgresDialect) InsertIgnore(table string, columns, conflictColumns []string, rows int) string {
+	return insertPrefix(d, "INSERT INTO", table, columns, rows) + onConflict(d, conflictColumns, nil, "EXCLUDED")
+}
+
+func (d postgresDialect) Upsert(table string, columns, conflictColumns, updateColumns []string, rows int) string {
+	return insertPrefix(d, "INSERT INTO", table, columns, rows) + onConflict(d, conflictColumns, updateColumns, "EXCLUDED")
+}
+
+func (postgresDialect) LimitOffset(limit, offset int) string { return limitOffset(limit, offset, "") }
+
+func (postgresDialect) BoolLiteral(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func (postgresDialect) TimeLiteral(t time.Time) string {
+	return "'" + t.Format("2006-01-02 15:04:05.999999-07:00") + "'::timestamptz"
+}
+
+func (postgresDialect) SupportsReturning() bool { return true }
+
+func (d postgresDialect) Returning(columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return " RETURNING " + quoteIdents(d, columns)
+}
+
+func (postgresDialect) ServerInfoQueries() ServerInfoQueries {
+	return ServerInfoQueries{
+		Version:  "SHOW server_version",
+		User:     "SELECT current_user",
+		Database: "SELECT current_database()",
+		Schema:   "SELECT current_schema()",
+		TLS:      "SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()",
+		ReadOnly: "SELECT current_setting('transaction_read_only') = 'on'",
+		Replica:  "SELECT pg_is_in_recovery()",
+
+		SchemaAccess: "SELECT has_schema_privilege(nspname, 'USAGE') FROM pg_namespace WHERE nspname = $1",
+	}
+}
+
+// mysqlDialect implements Dialect for go-sql-driver/mysql
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) DSN(config *Config) string {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		config.Database.Username,
+		config.Database.Password,
+		config.Database.Host,
+		config.Database.Port,
+		config.Database.DBName,
+	)
+	if timeout := connectTimeout(config); timeout > 0 {
+		dsn += "&timeout=" + timeout.String()
+	}
+	return dsn
+}
+
+func (mysqlDialect) QuoteIdent(name string) string { return quoteIdentWith(name, "`") }
+
+func (mysqlDialect) Placeholder(int) string { return "?" }
+
+func (mysqlDialect) MaxParams() int { return 65535 }
+
+// InsertIgnore uses INSERT IGNORE; MySQL has no conflict target so
+// conflictColumns is unused
+func (d mysqlDialect) InsertIgnore(table string, columns, _ []string, rows int) string {
+	return insertPrefix(d, "INSERT IGNORE INTO", table, columns, rows)
+}
+
+func (d mysqlDialect) Upsert(table string, columns, _, updateColumns []string, rows int) string {
+	query := insertPrefix(d, "INSERT INTO", table, columns, rows)
+	if len(updateColumns) == 0 {
+		// A no-op assignment keeps the statement valid while skipping conflicts
+		updateColumns = columns[:1]
+	}
+	sets := make([]string, len(updateColumns))
+	for i, col := range updateColumns {
+		sets[i] = fmt.Sprintf("%s = VALUES(%s)", d.QuoteIdent(col), d.QuoteIdent(col))
+	}
+	return query + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func (mysqlDialect) LimitOffset(limit, offset int) string {
+	return limitOffset(limit, offset, "18446744073709551615")
+}
+
+func (mysqlDialect) BoolLiteral(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (mysqlDialect) TimeLiteral(t time.Time) string {
+	return "'" + t.Format("2006-01-02 15:04:05.999999") + "'"
+}
+
+func (mysqlDialect) SupportsReturning() bool { return false }
+
+func (mysqlDialect) Returning([]string) string { return "" }
+
+// ServerInfoQueries reads TLS and replication state from
+// performance_schema, which may be disabled or restricted to the user
+func (mysqlDialect) ServerInfoQueries() ServerInfoQueries {
+	return ServerInfoQueries{
+		Version:  "SELECT VERSION()",
+		User:     "SELECT CURRENT_USER()",
+		Database: "SELECT DATABASE()",
+		Schema:   "SELECT DATABASE()",
+		TLS:      "SELECT VARIABLE_VALUE <> '' FROM performance_schema.session_status WHERE VARIABLE_NAME = 'Ssl_cipher'",
+		ReadOnly: "SELECT @@global.read_only",
+		Replica:  "SELECT COUNT(*) > 0 FROM performance_schema.replication_connection_status",
+
+		// information_schema only lists schemas the user has privileges on
+		SchemaAccess: "SELECT TRUE FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?",
+	}
+}
+
+// sqliteDialect implements Dialect for mattn/go-sqlite3
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite3" }
+
+func (sqliteDialect) DSN(config *Config) string { return config.Database.Filepath }
+
+func (sqliteDialect) QuoteIdent(name string) string { return quoteIdentWith(name, `"`) }
+
+func (sqliteDialect) Placeholder(int) string { return "?" }
+
+// MaxParams returns SQLITE_MAX_VARIABLE_NUMBER, which was raised from 999
+// to 32766 in SQLite 3.32.0
+func (sqliteDialect) MaxParams() int {
+	if _, version, _ := sqlite3.Version(); version < 3032000 {
+		return 999
+	}
+	return 32766
+}
+
+func (d sqliteDialect) InsertIgnore(table string, columns, conflictColumns []string, rows int) string {
+	return insertPrefix(d, "INSERT INTO", table, columns, rows) + onConflict(d, conflictColumns, nil, "excluded")
+}
+
+func (d sqliteDialect) Upsert(table string, columns, conflictColumns, updateColumns []string, rows int) string {
+	return insertPrefix(d, "INSERT INTO", table, columns, rows) + onConflict(d, conflictColumns, updateColumns, "excluded")
+}
+
+func (sqliteDialect) LimitOffset(limit, offset int) string { return limitOffset(limit, offset, "-1") }
+
+func (sqliteDialect) BoolLiteral(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (sqliteDialect) TimeLiteral(t time.Time) string {
+	return "'" + t.Format("2006-01-02 15:04:05.999999999-07:00") + "'"
+}
+
+// SupportsReturning is true for the SQLite 3.35+ bundled with go-sqlite3
+func (sqliteDialect) SupportsReturning() bool { return true }
+
+func (d sqliteDialect) Returning(columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return " RETURNING " + quoteIdents(d, columns)
+}
+
+// ServerInfoQueries reports the main database file as the database;
+// SQLite has no users, TLS, replication or schema permissions
+func (sqliteDialect) ServerInfoQueries() ServerInfoQueries {
+	return ServerInfoQueries{
+		Version:  "SELECT sqlite_version()",
+		Database: "SELECT file FROM pragma_database_list WHERE name = 'main'",
+		Schema:   "SELECT name FROM pragma_database_list WHERE seq = 0",
+		ReadOnly: "PRAGMA query_only",
+	}
+}
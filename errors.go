@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Driver-independent error classes. Errors returned by DatabaseConnection
+// match at most one of these with errors.Is, while the original driver
+// error remains reachable with errors.As.
+var (
+	ErrUniqueViolation     = errors.New("unique constraint violation")
+	ErrForeignKeyViolation = errors.New("foreign key constraint violation")
+	ErrDeadlock            = errors.New("deadlock detected")
+	ErrSerialization       = errors.New("serialization failure")
+	ErrConnection          = errors.New("connection failure")
+	ErrTimeout             = errors.New("timeout")
+	ErrAuth                = errors.New("authentication failure")
+	ErrConfig              = errors.New("invalid configuration")
+)
+
+// Error is a driver error tagged with its class
+type Error struct {
+	Class error
+	Err   error
+}
+
+// Error returns the driver's message unchanged
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap exposes both the class and the driver error to errors.Is/As
+func (e *Error) Unwrap() []error {
+	return []error{e.Class, e.Err}
+}
+
+// ClassifyError returns the class of err, or nil when it doesn't match
+// any class. It is useful for errors the package can't wrap itself, such
+// as those returned by sql.Row.Scan.
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPostgres(pqErr)
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return classifyMySQL(mysqlErr)
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return classifySQLite(sqliteErr)
+	}
+
+	return classifyTransport(err)
+}
+
+// wrapError tags err with its class, leaving unclassified errors as is
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	if class := ClassifyError(err); class != nil {
+		return &Error{Class: class, Err: err}
+	}
+	return err
+}
+
+// configError tags err as a configuration problem
+func configError(err error) error {
+	return &Error{Class: ErrConfig, Err: err}
+}
+
+// classifyPostgres maps SQLSTATE codes to error classes
+func classifyPostgres(err *pq.Error) error {
+	switch err.Code {
+	case "23505":
+		return ErrUniqueViolation
+	case "23503":
+		return ErrForeignKeyViolation
+	case "40P01":
+		return ErrDeadlock
+	case "40001":
+		return ErrSerialization
+	case "57014", "55P03":
+		// query_canceled (statement_timeout) and lock_not_available
+		return ErrTimeout
+	case "53300", "57P01", "57P02", "57P03":
+		// too_many_connections and server shutdown
+		return ErrConnection
+	}
+
+	switch err.Code.Class() {
+	case "08":
+		return ErrConnection
+	case "28":
+		return ErrAuth
+	}
+	return nil
+}
+
+// classifyMySQL maps MySQL server error numbers to error classes
+func classifyMySQL(err *mysql.MySQLError) error {
+	switch err.Number {
+	case 1062, 1586:
+		return ErrUniqueViolation
+	case 1216, 1217, 1451, 1452:
+		return ErrForeignKeyViolation
+	case 1213:
+		return ErrDeadlock
+	case 1205, 3024:
+		// Lock wait timeout and max_execution_time exceeded
+		return ErrTimeout
+	case 1040, 1053, 1152, 1153, 1158, 1159, 1160, 1161:
+		// Too many connections, shutdown and network errors
+		return ErrConnection
+	case 1044, 1045, 1698, 1820:
+		return ErrAuth
+	}
+	return nil
+}
+
+// classifySQLite maps SQLite result codes to error classes
+func classifySQLite(err sqlite3.Error) error {
+	switch err.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return ErrUniqueViolation
+	case sqlite3.ErrConstraintForeignKey:
+		return ErrForeignKeyViolation
+	}
+
+	switch err.Code {
+	case sqlite3.ErrBusy:
+		// busy_timeout expired while waiting for another writer. ErrLocked,
+		// a conflict within one connection or shared cache, fails at once
+		// and is neither a timeout nor a deadlock, so it stays unclassified.
+		return ErrTimeout
+	case sqlite3.ErrCantOpen, sqlite3.ErrNotADB:
+		return ErrConnection
+	case sqlite3.ErrAuth, sqlite3.ErrPerm:
+		return ErrAuth
+	}
+	return nil
+}
+
+// classifyTransport recognizes network, timeout and connection errors
+// that don't come from the database server
+func classifyTransport(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+
+	switch {
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, mysql.ErrInvalidConn),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET):
+		return ErrConnection
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return ErrConnection
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+var (
+	// inListPattern matches an IN list made up only of placeholders
+	inListPattern = regexp.MustCompile(`\bin ?\( ?\?(?: ?, ?\?)* ?\)`)
+
+	// valuesListPattern matches the row tuples of a multi-row VALUES clause
+	valuesListPattern = regexp.MustCompile(`\bvalues ?(\([^()]*\))(?: ?, ?\([^()]*\))+`)
+)
+
+// NormalizeQuery reduces a SQL statement to a canonical form so that
+// statements differing only in literal values compare equal. String and
+// numeric literals and driver placeholders become ?, comments are
+// removed, whitespace is collapsed and unquoted text is lower-cased.
+// IN lists and multi-row VALUES clauses collapse to a single element so
+// their length doesn't change the fingerprint.
+func NormalizeQuery(query string) string {
+	normalized := stripLiterals(query)
+	normalized = inListPattern.ReplaceAllString(normalized, "in (?)")
+	normalized = valuesListPattern.ReplaceAllString(normalized, "values $1")
+	return normalized
+}
+
+// stripLiterals performs the character-level part of NormalizeQuery
+func stripLiterals(query string) string {
+	var sb strings.Builder
+	sb.Grow(len(query))
+
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			// Line comment
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			// Block comment
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+			space = true
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		}
+
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'':
+			// String literal, with '' and \' escapes
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' {
+					i++
+				} else if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+			}
+			sb.WriteByte('?')
+		case c == '"' || c == '`':
+			// Quoted identifier, kept verbatim
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				sb.WriteString(query[i:])
+				i = len(query)
+				break
+			}
+			sb.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			// Postgres positional placeholder
+			for i+1 < len(query) && isDigit(query[i+1]) {
+				i++
+			}
+			sb.WriteByte('?')
+		case isDigit(c) && !endsWithIdent(sb.String()):
+			// Numeric literal
+			for i+1 < len(query) && (isDigit(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			sb.WriteByte('?')
+		default:
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
+
+// Fingerprint returns a short stable hash of the normalized query
+func Fingerprint(query string) string {
+	return hashNormalized(NormalizeQuery(query))
+}
+
+// StatementFingerprint is Fingerprint without collapsing IN lists and
+// VALUES clauses, so statements binding a different number of arguments
+// get different fingerprints. Redaction positions are keyed by it.
+func StatementFingerprint(query string) string {
+	return hashNormalized(stripLiterals(query))
+}
+
+// hashNormalized returns the fingerprint of an already normalized query
+func hashNormalized(normalized string) string {
+	sum := sha1.Sum([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// endsWithIdent reports whether s ends in an identifier character, in
+// which case a following digit is part of that identifier
+func endsWithIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[len(s)-1]
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z')
+}
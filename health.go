@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// healthBuckets are the upper bounds, in seconds, of the ping latency
+// histogram exported on /metrics
+var healthBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// HealthChecker serves liveness, readiness and metrics endpoints for a
+// connection. Readiness pings are cached for a fixed time, so frequent
+// probes from several sources send at most one ping per cache period.
+type HealthChecker struct {
+	dc       *DatabaseConnection
+	cacheTTL time.Duration
+	timeout  time.Duration
+	started  time.Time
+
+	// mu serializes pings and guards the fields below
+	mu      sync.Mutex
+	checked time.Time
+	latency time.Duration
+	err     error
+
+	pings    uint64
+	failures uint64
+	sum      time.Duration
+	buckets  []uint64
+}
+
+// ReadyStatus is the outcome of a readiness check
+type ReadyStatus struct {
+	Ready      bool          `json:"ready"`
+	Latency    time.Duration `json:"latency"`
+	CheckedAt  time.Time     `json:"checked_at"`
+	Cached     bool          `json:"cached"`
+	Error      string        `json:"error,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+}
+
+// NewHealthChecker creates a checker that reuses a ping result for
+// cacheTTL and gives each ping up to timeout, or the connection's ping
+// timeout when timeout is zero
+func NewHealthChecker(dc *DatabaseConnection, cacheTTL, timeout time.Duration) *HealthChecker {
+	return &HealthChecker{
+		dc:       dc,
+		cacheTTL: cacheTTL,
+		timeout:  timeout,
+		started:  time.Now(),
+		buckets:  make([]uint64, len(healthBuckets)),
+	}
+}
+
+// Ready returns the cached readiness status, pinging the database first
+// when the cached result has expired. The ping is detached from ctx so a
+// caller that goes away doesn't cache a failure for everyone else; a
+// canceled ping is reported but never cached.
+func (h *HealthChecker) Ready(ctx context.Context) ReadyStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cached := !h.checked.IsZero() && time.Since(h.checked) < h.cacheTTL
+	if !cached {
+		pingCtx, cancel := withTimeout(context.WithoutCancel(ctx), h.timeout)
+		latency, err := h.dc.Ping(pingCtx)
+		cancel()
+		if errors.Is(err, context.Canceled) {
+			return ReadyStatus{CheckedAt: time.Now(), Error: err.Error(), ErrorClass: FailureClass(err)}
+		}
+		h.latency, h.err = latency, err
+		h.checked = time.Now()
+		h.observe()
+	}
+
+	status := ReadyStatus{
+		Ready:     h.err == nil,
+		Latency:   h.latency,
+		CheckedAt: h.checked,
+		Cached:    cached,
+	}
+	if h.err != nil {
+		status.Error = h.err.Error()
+		status.ErrorClass = FailureClass(h.err)
+	}
+	return status
+}
+
+// observe adds the latest ping to the metrics
+func (h *HealthChecker) observe() {
+	h.pings++
+	if h.err != nil {
+		h.failures++
+		return
+	}
+	h.sum += h.latency
+	seconds := h.latency.Seconds()
+	for i, bound := range healthBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+// Handler returns a mux serving /healthz, /readyz and /metrics.
+// /healthz reports that the process is alive without touching the
+// database; /readyz answers 200 or 503 from the cached ping; /metrics
+// exposes ping latency in the Prometheus text format.
+func (h *HealthChecker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.serveHealthz)
+	mux.HandleFunc("/readyz", h.serveReadyz)
+	mux.HandleFunc("/metrics", h.serveMetrics)
+	return mux
+}
+
+// serveHealthz reports process liveness
+func (h *HealthChecker) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"uptime": time.Since(h.started).Round(time.Second).String(),
+	})
+}
+
+// serveReadyz reports whether the database answers pings
+func (h *HealthChecker) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	status := h.Ready(r.Context())
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+// serveMetrics writes the ping metrics, refreshing the cached ping so
+// scrapes alone keep them current
+func (h *HealthChecker) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	status := h.Ready(r.Context())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	up := 0
+	if status.Ready {
+		up = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP db_up Whether the last ping succeeded.")
+	fmt.Fprintln(w, "# TYPE db_up gauge")
+	fmt.Fprintf(w, "db_up %d\n", up)
+	fmt.Fprintln(w, "# HELP db_ping_last_latency_seconds Latency of the last ping.")
+	fmt.Fprintln(w, "# TYPE db_ping_last_latency_seconds gauge")
+	fmt.Fprintf(w, "db_ping_last_latency_seconds %g\n", h.latency.Seconds())
+	fmt.Fprintln(w, "# HELP db_ping_failures_total Pings that failed.")
+	fmt.Fprintln(w, "# TYPE db_ping_failures_total counter")
+	fmt.Fprintf(w, "db_ping_failures_total %d\n", h.failures)
+	fmt.Fprintln(w, "# HELP db_ping_latency_seconds Latency of successful pings.")
+	fmt.Fprintln(w, "# TYPE db_ping_latency_seconds histogram")
+	for i, bound := range healthBuckets {
+		fmt.Fprintf(w, "db_ping_latency_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i])
+	}
+	fmt.Fprintf(w, "db_ping_latency_seconds_bucket{le=\"+Inf\"} %d\n", h.pings-h.failures)
+	fmt.Fprintf(w, "db_ping_latency_seconds_sum %g\n", h.sum.Seconds())
+	fmt.Fprintf(w, "db_ping_latency_seconds_count %d\n", h.pings-h.failures)
+}
+
+// writeJSON writes v as the JSON response body with the given status
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
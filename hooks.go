@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Operations reported in QueryEvent.Op
+const (
+	OpQuery    = "query"
+	OpQueryRow = "query_row"
+	OpExec     = "exec"
+	OpPrepare  = "prepare"
+	OpBegin    = "begin"
+	OpCommit   = "commit"
+	OpRollback = "rollback"
+)
+
+// QueryEvent describes a single database call as seen by hooks. Before
+// receives the call's inputs; After additionally sees its outcome.
+type QueryEvent struct {
+	Op       string
+	Query    string
+	Args     []interface{}
+	InTx     bool
+	Prepared bool
+
+	Start        time.Time
+	Duration     time.Duration
+	RowsAffected int64 // -1 when unknown
+	Err          error
+}
+
+// Hook observes database calls. Before runs ahead of the call and may
+// return a derived context that is passed to the call and to After.
+type Hook interface {
+	Before(ctx context.Context, event *QueryEvent) context.Context
+	After(ctx context.Context, event *QueryEvent)
+}
+
+// AddHook appends h to the hook chain. Before callbacks run in
+// registration order and After callbacks in reverse, so the first hook
+// wraps all others. Hooks must be added before the connection is shared
+// between goroutines.
+func (dc *DatabaseConnection) AddHook(h Hook) {
+	dc.hooks = append(dc.hooks, h)
+}
+
+// before stamps the event start time and runs every Before callback
+func (dc *DatabaseConnection) before(ctx context.Context, event *QueryEvent) context.Context {
+	event.Start = time.Now()
+	for _, h := range dc.hooks {
+		ctx = h.Before(ctx, event)
+	}
+	return ctx
+}
+
+// after records the event duration and runs every After callback in
+// reverse order
+func (dc *DatabaseConnection) after(ctx context.Context, event *QueryEvent) {
+	event.Duration = time.Since(event.Start)
+	for i := len(dc.hooks) - 1; i >= 0; i-- {
+		dc.hooks[i].After(ctx, event)
+	}
+}
+
+// newEvent creates an event with unknown rows affected
+func newEvent(op, query string, args []interface{}) *QueryEvent {
+	return &QueryEvent{Op: op, Query: query, Args: args, RowsAffected: -1}
+}
+
+// loggingHook is the built-in hook that writes debug, error and slow
+// query entries to the connection's logger
+type loggingHook struct {
+	dc *DatabaseConnection
+}
+
+// loggingMessages maps each operation to its debug log message
+var loggingMessages = map[string]string{
+	OpQuery:    "Executing database query",
+	OpQueryRow: "Executing single row query",
+	OpExec:     "Executing database modification",
+	OpPrepare:  "Preparing statement",
+	OpBegin:    "Beginning transaction",
+	OpCommit:   "Committing transaction",
+	OpRollback: "Rolling back transaction",
+}
+
+// Before logs the statement at debug level
+func (h loggingHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	logger := h.dc.contextLogger(ctx)
+	entry := logger.Debug()
+	if !entry.Enabled() {
+		return ctx
+	}
+	if event.Query != "" {
+		entry = entry.
+			Str("query", event.Query).
+			Interface("args", h.dc.redactor.Args(event.Query, event.Args))
+	}
+	if event.InTx {
+		entry = entry.Bool("in_tx", true)
+	}
+	entry.Msg(loggingMessages[event.Op])
+	return ctx
+}
+
+// After logs failures at error level and slow statements at warn level
+func (h loggingHook) After(ctx context.Context, event *QueryEvent) {
+	logger := h.dc.contextLogger(ctx)
+	if event.Err != nil {
+		entry := logger.Error().Err(event.Err).Str("op", event.Op)
+		if entry.Enabled() && event.Query != "" {
+			entry = entry.
+				Str("query", event.Query).
+				Interface("args", h.dc.redactor.Args(event.Query, event.Args))
+		}
+		entry.Msg("Query execution failed")
+	}
+
+	switch event.Op {
+	case OpQuery, OpQueryRow, OpExec:
+		h.dc.logSlowQuery(logger, event.Query, event.Args, event.Duration, event.RowsAffected)
+	}
+}
+
+// runQuery wraps a row-returning call with the hook chain
+func (dc *DatabaseConnection) runQuery(ctx context.Context, event *QueryEvent, fn func(context.Context) (*sql.Rows, error)) (*sql.Rows, error) {
+	ctx = dc.before(ctx, event)
+	rows, err := fn(ctx)
+	err = wrapError(err)
+	event.Err = err
+	dc.after(ctx, event)
+	if err == nil && dc.openRows != nil {
+		dc.openRows.add(rows, event.Query, RequestIDFromContext(ctx))
+	}
+	return rows, err
+}
+
+// runQueryRow wraps a single-row call with the hook chain
+func (dc *DatabaseConnection) runQueryRow(ctx context.Context, event *QueryEvent, fn func(context.Context) *sql.Row) *sql.Row {
+	ctx = dc.before(ctx, event)
+	row := fn(ctx)
+	event.Err = wrapError(row.Err())
+	dc.after(ctx, event)
+	return row
+}
+
+// runExec wraps a modification with the hook chain, recording rows affected
+func (dc *DatabaseConnection) runExec(ctx context.Context, event *QueryEvent, fn func(context.Context) (sql.Result, error)) (sql.Result, error) {
+	ctx, cancel := dc.execContext(ctx)
+	defer cancel()
+	ctx = dc.before(ctx, event)
+	result, err := fn(ctx)
+	err = wrapError(err)
+	event.Err = err
+	if err == nil {
+		if n, raErr := result.RowsAffected(); raErr == nil {
+			event.RowsAffected = n
+		}
+	}
+	dc.after(ctx, event)
+	return result, err
+}
+
+// execContext bounds ctx by the default query timeout when it has no
+// deadline of its own. Only Exec is bounded: rows returned by a query
+// are read after the call returns, so queries take the caller's context.
+func (dc *DatabaseConnection) execContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, dc.timeouts.query)
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// LoggerConfig controls where and how a connection writes its logs
+type LoggerConfig struct {
+	OutputPath  string `yaml:"output_path"`
+	Console     bool   `yaml:"console"`
+	MaxSizeMB   int    `yaml:"max_size_mb"`
+	RotateEvery string `yaml:"rotate_every"`
+	MaxAgeDays  int    `yaml:"max_age_days"`
+	MaxBackups  int    `yaml:"max_backups"`
+	Compress    bool   `yaml:"compress"`
+}
+
+// parseLogLevel converts a log_level value to a zerolog level, defaulting
+// to info for empty or unknown values. ok is false for unknown values.
+func parseLogLevel(level string) (parsed zerolog.Level, ok bool) {
+	switch level {
+	case "debug":
+		return zerolog.DebugLevel, true
+	case "info", "":
+		return zerolog.InfoLevel, true
+	case "warn":
+		return zerolog.WarnLevel, true
+	case "error":
+		return zerolog.ErrorLevel, true
+	default:
+		return zerolog.InfoLevel, false
+	}
+}
+
+// setupLogger builds a logger for a single connection. The level is set
+// on the logger itself rather than globally, so connections with
+// different levels don't affect each other or the host application. The
+// returned closer releases the log file, if any.
+func setupLogger(config *Config) (zerolog.Logger, io.Closer, error) {
+	var out io.Writer = os.Stdout
+	var closer io.Closer
+
+	logConfig := config.Database.Logger
+	if logConfig.OutputPath != "" {
+		file, err := newRotatingFile(logConfig)
+		if err != nil {
+			return zerolog.Nop(), nil, err
+		}
+		out = file
+		closer = file
+	}
+
+	if logConfig.Console {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339, NoColor: logConfig.OutputPath != ""}
+	}
+
+	level, ok := parseLogLevel(config.Database.LogLevel)
+	logger := zerolog.New(out).
+		Level(level).
+		With().
+		Timestamp().
+		Logger()
+	if !ok {
+		logger.Warn().Str("provided_level", config.Database.LogLevel).Msg("Invalid log level, defaulting to info")
+	}
+
+	return logger, closer, nil
+}
+
+// rotatingFile is a log file rotated by size through lumberjack and,
+// optionally, by age once the current file is older than rotateEvery
+type rotatingFile struct {
+	mu          sync.Mutex
+	file        *lumberjack.Logger
+	rotateEvery time.Duration
+	opened      time.Time
+}
+
+// newRotatingFile creates the rotating writer described by config
+func newRotatingFile(config LoggerConfig) (*rotatingFile, error) {
+	var rotateEvery time.Duration
+	if config.RotateEvery != "" {
+		d, err := time.ParseDuration(config.RotateEvery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logger rotate_every: %v", err)
+		}
+		rotateEvery = d
+	}
+
+	return &rotatingFile{
+		file: &lumberjack.Logger{
+			Filename:   config.OutputPath,
+			MaxSize:    config.MaxSizeMB,
+			MaxAge:     config.MaxAgeDays,
+			MaxBackups: config.MaxBackups,
+			Compress:   config.Compress,
+		},
+		rotateEvery: rotateEvery,
+		opened:      time.Now(),
+	}, nil
+}
+
+// Write writes p to the current file, rotating first if it has aged out
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.rotateEvery > 0 {
+		r.mu.Lock()
+		if time.Since(r.opened) >= r.rotateEvery {
+			if err := r.file.Rotate(); err != nil {
+				r.mu.Unlock()
+				return 0, err
+			}
+			r.opened = time.Now()
+		}
+		r.mu.Unlock()
+	}
+	return r.file.Write(p)
+}
+
+// Close closes the current log file
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
@@ -1,20 +1,78 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"text/tabwriter"
 
+	"github.com/rs/zerolog"
+
+	"your_module_path/builder"  // Replace with the actual path of your `builder` package
 	"your_module_path/database" // Replace with the actual path of your `database` package
 )
 
+// maxDeleteBatch caps the rows removed by each DELETE in -sync; the
+// dialect's bind parameter limit may lower it further
+const maxDeleteBatch = 500
+
+// securityList holds the parsed security.list file: each line maps a data
+// domain to a colon-separated list of technical identities
+type securityList struct {
+	identities map[string]struct{}
+	domains    map[string]struct{}
+	mappings   map[string]map[string]struct{}
+
+	// issues lists the problems found while parsing the file
+	issues []issue
+}
+
+// Kinds of issue found in security.list
+const (
+	issueFieldCount        = "field_count"
+	issueEmptyDomain       = "empty_domain"
+	issueEmptyIdentity     = "empty_identity"
+	issueDuplicateIdentity = "duplicate_identity"
+	issueInvalidName       = "invalid_name"
+)
+
+// issue is a problem on one line of security.list
+type issue struct {
+	Line    int    `json:"line"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// loadStep is the insertion of one table's rows
+type loadStep struct {
+	name    string
+	table   string
+	columns []string
+	rows    [][]interface{}
+}
+
 func main() {
 	// Command-line flags
 	configPath := flag.String("config", "config.yaml", "Path to the database configuration file")
 	filePath := flag.String("file", "", "Path to the security.list file")
+	continueOnError := flag.Bool("continue-on-error", false, "Commit each table separately and keep going after a failure instead of rolling back the whole load")
+	syncFlag := flag.Bool("sync", false, "Make the tables match the file, also deleting mappings that are not in it")
+	prune := flag.Bool("prune", false, "With -sync, also delete identities and domains that are not in the file")
+	maxDelete := flag.Float64("max-delete", 10, "With -sync, refuse to delete more than this percentage of any table's rows")
+	dryRun := flag.Bool("dry-run", false, "Print the changes the load or -sync would make without writing anything")
+	output := flag.String("output", "text", "Dry-run plan format: text or json")
+	strict := flag.Bool("strict", false, "Abort without loading if security.list has any issues")
+	namePattern := flag.String("name-pattern", "", "Regular expression every domain and identity must match (empty accepts any name)")
+	var timeoutFlags database.TimeoutsConfig
+	timeoutFlags.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Validate configPath flag
@@ -24,99 +82,814 @@ func main() {
 
 	// Validate filePath flag
 	if *filePath == "" {
-		fmt.Println("Please provide a file path with the -file flag.")
-		return
+		log.Fatal("Please provide a file path with the -file flag.")
 	}
 
-	// Open the file
-	file, err := os.Open(*filePath)
+	// Sync is all or nothing
+	if *syncFlag && *continueOnError {
+		log.Fatal("-sync runs in a single transaction and cannot be combined with -continue-on-error")
+	}
+	if *prune && !*syncFlag {
+		log.Fatal("-prune requires -sync")
+	}
+	if *output != "text" && *output != "json" {
+		log.Fatalf("Unknown -output %q, expected text or json", *output)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "output" && !*dryRun {
+			log.Fatal("-output requires -dry-run")
+		}
+	})
+	jsonOutput := *dryRun && *output == "json"
+	var pattern *regexp.Regexp
+	if *namePattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(*namePattern); err != nil {
+			log.Fatalf("Invalid -name-pattern: %v", err)
+		}
+	}
+
+	// Read and parse the file
+	list, err := readSecurityList(*filePath, pattern)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return
+		log.Fatalf("Error reading file: %v", err)
+	}
+	if *strict && len(list.issues) > 0 {
+		printIssues(os.Stderr, "Errors", list.issues)
+		log.Fatalf("Aborting: %s has %d issue(s) and -strict is set", *filePath, len(list.issues))
+	}
+
+	// A skipped line would read as a removal, so -sync refuses to run
+	// until every line is loaded
+	if *syncFlag && !*dryRun {
+		if err := syncIssuesError(list); err != nil {
+			printIssues(os.Stderr, "Errors", list.droppingIssues())
+			log.Fatalf("Refusing to sync: %v", err)
+		}
+	}
+
+	// Read configuration, letting the timeout flags override it
+	config, err := database.ReadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
+	config.Database.Timeouts = config.Database.Timeouts.Override(timeoutFlags)
+
+	// Create database connection, keeping library logs off a JSON plan
+	var logger *zerolog.Logger
+	if jsonOutput {
+		l := planLogger()
+		logger = &l
+	}
+	dbConn, err := database.Connect(config, logger)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+
+	if !jsonOutput {
+		fmt.Println("Successfully connected to the database!")
+	}
+
+	var failed bool
+	switch {
+	case *dryRun:
+		failed = planOnly(context.Background(), dbConn, list, *syncFlag, *prune, *maxDelete, jsonOutput)
+	case *syncFlag:
+		failed = syncAll(context.Background(), dbConn, list, *prune, *maxDelete)
+	case *continueOnError:
+		failed = loadEach(context.Background(), dbConn, list.steps())
+	default:
+		failed = loadAll(context.Background(), dbConn, list.steps())
+	}
+
+	if !jsonOutput {
+		printIssues(os.Stdout, "Warnings", list.issues)
+	}
+
+	dbConn.Close()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// planLogger writes warnings and errors to stderr so they never mix with
+// a JSON plan on stdout
+func planLogger() zerolog.Logger {
+	return zerolog.New(os.Stderr).Level(zerolog.WarnLevel).With().Timestamp().Logger()
+}
+
+// readSecurityList parses the security.list file at path. Problems are
+// recorded as issues with their line numbers: records with the wrong
+// field count or an empty domain are skipped, as are empty identities and
+// names that don't match pattern when it is not nil. Duplicate identities
+// are loaded once.
+func readSecurityList(path string, pattern *regexp.Regexp) (*securityList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
 	defer file.Close()
 
-	// Read and parse the file
 	reader := csv.NewReader(file)
 	reader.Comma = ','
 	reader.FieldsPerRecord = -1
 
-	identities := make(map[string]struct{})
-	domains := make(map[string]struct{})
-	mappings := make(map[string]map[string]struct{})
-
-	records, err := reader.ReadAll()
-	if err != nil {
-		fmt.Println("Error reading file:", err)
-		return
-	}
+	list := newSecurityList()
+	// seen records the line on which each domain's identities were listed
+	seen := make(map[string]map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
 
-	for _, record := range records {
 		if len(record) != 2 {
+			list.addIssue(line, issueFieldCount, "expected 2 fields (domain,identities), got %d", len(record))
 			continue
 		}
 		domain := strings.TrimSpace(record[0])
+		if domain == "" {
+			list.addIssue(line, issueEmptyDomain, "empty domain")
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(domain) {
+			list.addIssue(line, issueInvalidName, "domain %q does not match -name-pattern", domain)
+			continue
+		}
 		identityList := strings.Split(strings.TrimSpace(record[1]), ":")
 
-		domains[domain] = struct{}{}
-		if _, exists := mappings[domain]; !exists {
-			mappings[domain] = make(map[string]struct{})
+		list.domains[domain] = struct{}{}
+		if seen[domain] == nil {
+			seen[domain] = make(map[string]int)
 		}
 
 		for _, identity := range identityList {
 			identity = strings.TrimSpace(identity)
-			identities[identity] = struct{}{}
-			mappings[domain][identity] = struct{}{}
+			switch {
+			case identity == "":
+				list.addIssue(line, issueEmptyIdentity, "empty identity for domain %q", domain)
+				continue
+			case pattern != nil && !pattern.MatchString(identity):
+				list.addIssue(line, issueInvalidName, "identity %q does not match -name-pattern", identity)
+				continue
+			}
+			if first, ok := seen[domain][identity]; ok {
+				list.addIssue(line, issueDuplicateIdentity, "identity %q already listed for domain %q on line %d", identity, domain, first)
+				continue
+			}
+			seen[domain][identity] = line
+
+			list.identities[identity] = struct{}{}
+			list.addMapping(domain, identity)
 		}
 	}
 
-	// Create database connection
-	dbConn, err := database.NewDatabaseConnection(*configPath)
-	if err != nil {
-		log.Fatalf("Database connection failed: %v", err)
+	return list, nil
+}
+
+// addIssue records a problem found on line
+func (l *securityList) addIssue(line int, kind, format string, args ...interface{}) {
+	l.issues = append(l.issues, issue{Line: line, Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+// printIssues writes issues to w under title, one per line
+func printIssues(w io.Writer, title string, issues []issue) {
+	if len(issues) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s (%d):\n", title, len(issues))
+	for _, is := range issues {
+		fmt.Fprintf(w, "  line %d: %s (%s)\n", is.Line, is.Message, is.Kind)
 	}
-	defer dbConn.Close()
+}
+
+// newSecurityList creates an empty list
+func newSecurityList() *securityList {
+	return &securityList{
+		identities: make(map[string]struct{}),
+		domains:    make(map[string]struct{}),
+		mappings:   make(map[string]map[string]struct{}),
+	}
+}
 
-	fmt.Println("Successfully connected to the database!")
+// addMapping grants identity access to domain
+func (l *securityList) addMapping(domain, identity string) {
+	if _, exists := l.mappings[domain]; !exists {
+		l.mappings[domain] = make(map[string]struct{})
+	}
+	l.mappings[domain][identity] = struct{}{}
+}
+
+// mappingCount returns the number of identity-domain pairs
+func (l *securityList) mappingCount() int {
+	n := 0
+	for _, identities := range l.mappings {
+		n += len(identities)
+	}
+	return n
+}
+
+// steps returns the inserts for identities, domains and mappings, in
+// that order and with rows sorted so runs are reproducible
+func (l *securityList) steps() []loadStep {
+	var mappingRows [][]interface{}
+	for _, domain := range sortedKeys(l.domains) {
+		for _, identity := range sortedKeys(l.mappings[domain]) {
+			mappingRows = append(mappingRows, []interface{}{identity, domain})
+		}
+	}
 
-	// Insert identities
-	for identity := range identities {
-		_, err := dbConn.DB.Exec(
-			`INSERT INTO technical_identities (identity) VALUES ($1)
-			ON CONFLICT (identity) DO NOTHING`,
-			identity,
-		)
+	return []loadStep{
+		{"Identities", "technical_identities", []string{"identity"}, singleColumnRows(sortedKeys(l.identities))},
+		{"Domains", "data_domains", []string{"domain_name"}, singleColumnRows(sortedKeys(l.domains))},
+		{"Mappings", "data_domain_identities", []string{"identity", "domain_name"}, mappingRows},
+	}
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// singleColumnRows turns values into one-column insert rows
+func singleColumnRows(values []string) [][]interface{} {
+	rows := make([][]interface{}, len(values))
+	for i, value := range values {
+		rows[i] = []interface{}{value}
+	}
+	return rows
+}
+
+// loadAll runs every step in a single transaction, rolling all of them
+// back on the first error. It reports whether the load failed.
+func loadAll(ctx context.Context, dbConn *database.DatabaseConnection, steps []loadStep) bool {
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		fmt.Println("Error starting transaction:", err)
+		return true
+	}
+	defer tx.Rollback()
+
+	for _, step := range steps {
+		result, err := tx.BulkInsertIgnore(ctx, step.table, step.columns, step.columns, step.rows)
 		if err != nil {
-			fmt.Println("Error inserting identity:", err)
+			fmt.Printf("Error inserting %s: %v\n", strings.ToLower(step.name), err)
+			fmt.Println("Load rolled back; no changes were made.")
+			return true
 		}
+		fmt.Printf("%s: %d inserted, %d skipped\n", step.name, result.Inserted, result.Skipped)
 	}
 
-	// Insert domains
-	for domain := range domains {
-		_, err := dbConn.DB.Exec(
-			`INSERT INTO data_domains (domain_name) VALUES ($1)
-			ON CONFLICT (domain_name) DO NOTHING`,
-			domain,
-		)
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error committing transaction:", err)
+		return true
+	}
+
+	fmt.Println("Data insertion completed successfully!")
+	return false
+}
+
+// loadEach runs each step in its own transaction and keeps going after a
+// failed step. It reports whether any step failed.
+func loadEach(ctx context.Context, dbConn *database.DatabaseConnection, steps []loadStep) bool {
+	failures := 0
+	for _, step := range steps {
+		result, err := dbConn.BulkInsertIgnore(ctx, step.table, step.columns, step.columns, step.rows)
 		if err != nil {
-			fmt.Println("Error inserting domain:", err)
+			fmt.Printf("Error inserting %s: %v\n", strings.ToLower(step.name), err)
+			failures++
+			continue
+		}
+		fmt.Printf("%s: %d inserted, %d skipped\n", step.name, result.Inserted, result.Skipped)
+	}
+
+	if failures > 0 {
+		fmt.Printf("Data insertion finished with %d failed step(s).\n", failures)
+		return true
+	}
+	fmt.Println("Data insertion completed successfully!")
+	return false
+}
+
+// mapping is one identity's access to a data domain
+type mapping struct {
+	identity string
+	domain   string
+}
+
+// changePlan lists the rows a load or sync adds and removes to bring the
+// tables in line with security.list
+type changePlan struct {
+	addIdentities    []string
+	removeIdentities []string
+	addDomains       []string
+	removeDomains    []string
+	addMappings      []mapping
+	removeMappings   []mapping
+
+	// Row counts of the tables before the sync
+	identities int
+	domains    int
+	mappings   int
+}
+
+// newChangePlan compares the file with the current tables. Mappings
+// missing from the file are removed only when sync is set, and
+// identities and domains missing from it only when prune is set too; by
+// then every mapping that referenced them is removed, so they are
+// orphans.
+func newChangePlan(file, current *securityList, sync, prune bool) *changePlan {
+	plan := &changePlan{
+		addIdentities: missingKeys(file.identities, current.identities),
+		addDomains:    missingKeys(file.domains, current.domains),
+		addMappings:   missingMappings(file.mappings, current.mappings),
+
+		identities: len(current.identities),
+		domains:    len(current.domains),
+		mappings:   current.mappingCount(),
+	}
+	if sync {
+		plan.removeMappings = missingMappings(current.mappings, file.mappings)
+	}
+	if sync && prune {
+		plan.removeIdentities = missingKeys(current.identities, file.identities)
+		plan.removeDomains = missingKeys(current.domains, file.domains)
+	}
+	return plan
+}
+
+// checkDeleteLimit refuses plans that delete more than maxPercent of the
+// rows of any table
+func (p *changePlan) checkDeleteLimit(maxPercent float64) error {
+	tables := []struct {
+		name          string
+		remove, total int
+	}{
+		{"mappings", len(p.removeMappings), p.mappings},
+		{"identities", len(p.removeIdentities), p.identities},
+		{"domains", len(p.removeDomains), p.domains},
+	}
+	for _, t := range tables {
+		if t.total == 0 {
+			continue
+		}
+		percent := float64(t.remove) * 100 / float64(t.total)
+		if percent > maxPercent {
+			return fmt.Errorf("would delete %d of %d %s (%.1f%%), above the -max-delete limit of %.1f%%",
+				t.remove, t.total, t.name, percent, maxPercent)
+		}
+	}
+	return nil
+}
+
+// missingKeys returns the keys of a that are not in b, sorted
+func missingKeys(a, b map[string]struct{}) []string {
+	var keys []string
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			keys = append(keys, key)
 		}
 	}
+	sort.Strings(keys)
+	return keys
+}
 
-	// Insert mappings
-	for domain, identitySet := range mappings {
-		for identity := range identitySet {
-			_, err := dbConn.DB.Exec(
-				`INSERT INTO data_domain_identities (identity, domain_name)
-				VALUES ($1, $2)
-				ON CONFLICT (identity, domain_name) DO NOTHING`,
-				identity, domain,
-			)
-			if err != nil {
-				fmt.Println("Error inserting mapping:", err)
+// missingMappings returns the mappings of a that are not in b, sorted by
+// domain and identity
+func missingMappings(a, b map[string]map[string]struct{}) []mapping {
+	var result []mapping
+	for domain, identities := range a {
+		for identity := range identities {
+			if _, ok := b[domain][identity]; !ok {
+				result = append(result, mapping{identity: identity, domain: domain})
 			}
 		}
 	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].domain != result[j].domain {
+			return result[i].domain < result[j].domain
+		}
+		return result[i].identity < result[j].identity
+	})
+	return result
+}
 
-	fmt.Println("Data insertion completed successfully!")
+// readCurrentState loads the identities, domains and mappings stored in
+// the tables
+func readCurrentState(tx *database.Tx, b *builder.Builder) (*securityList, error) {
+	state := newSecurityList()
+
+	err := readRows(tx, b.Select("identity").From("technical_identities"), func(values []string) {
+		state.identities[values[0]] = struct{}{}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading identities: %w", err)
+	}
+
+	err = readRows(tx, b.Select("domain_name").From("data_domains"), func(values []string) {
+		state.domains[values[0]] = struct{}{}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading domains: %w", err)
+	}
+
+	err = readRows(tx, b.Select("identity", "domain_name").From("data_domain_identities"), func(values []string) {
+		state.addMapping(values[1], values[0])
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading mappings: %w", err)
+	}
+
+	return state, nil
+}
+
+// readRows runs query and passes each row's string columns to fn
+func readRows(tx *database.Tx, query database.SQLBuilder, fn func(values []string)) error {
+	rows, err := tx.QueryFrom(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]string, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		fn(values)
+	}
+	return rows.Err()
+}
+
+// syncAll makes the tables match the file in a single transaction: it
+// adds what is missing, deletes mappings the file no longer lists and,
+// with prune, identities and domains it no longer lists. Nothing is
+// changed when the plan exceeds the -max-delete limit. It reports whether
+// the sync failed.
+func syncAll(ctx context.Context, dbConn *database.DatabaseConnection, list *securityList, prune bool, maxDelete float64) bool {
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		fmt.Println("Error starting transaction:", err)
+		return true
+	}
+	defer tx.Rollback()
+
+	b := builder.New(dbConn.Dialect())
+	current, err := readCurrentState(tx, b)
+	if err != nil {
+		fmt.Println("Error reading current state:", err)
+		return true
+	}
+
+	plan := newChangePlan(list, current, true, prune)
+	if err := plan.checkDeleteLimit(maxDelete); err != nil {
+		fmt.Println("Refusing to sync:", err)
+		return true
+	}
+
+	// Additions first, then deletions, mappings before the rows they reference
+	var mappingRows [][]interface{}
+	for _, m := range plan.addMappings {
+		mappingRows = append(mappingRows, []interface{}{m.identity, m.domain})
+	}
+	adds := []loadStep{
+		{"Identities", "technical_identities", []string{"identity"}, singleColumnRows(plan.addIdentities)},
+		{"Domains", "data_domains", []string{"domain_name"}, singleColumnRows(plan.addDomains)},
+		{"Mappings", "data_domain_identities", []string{"identity", "domain_name"}, mappingRows},
+	}
+	added := make([]int64, len(adds))
+	for i, step := range adds {
+		result, err := tx.BulkInsert(ctx, step.table, step.columns, step.rows)
+		if err != nil {
+			fmt.Printf("Error inserting %s: %v\n", strings.ToLower(step.name), err)
+			fmt.Println("Sync rolled back; no changes were made.")
+			return true
+		}
+		added[i] = result.Inserted
+	}
+
+	dialect := dbConn.Dialect()
+	removedMappings, err := deleteMappings(tx, b, plan.removeMappings, deleteBatch(dialect, 2))
+	if err != nil {
+		fmt.Println("Error deleting mappings:", err)
+		fmt.Println("Sync rolled back; no changes were made.")
+		return true
+	}
+	removedIdentities, err := deleteValues(tx, b, "technical_identities", "identity", plan.removeIdentities, deleteBatch(dialect, 1))
+	if err != nil {
+		fmt.Println("Error deleting identities:", err)
+		fmt.Println("Sync rolled back; no changes were made.")
+		return true
+	}
+	removedDomains, err := deleteValues(tx, b, "data_domains", "domain_name", plan.removeDomains, deleteBatch(dialect, 1))
+	if err != nil {
+		fmt.Println("Error deleting domains:", err)
+		fmt.Println("Sync rolled back; no changes were made.")
+		return true
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error committing transaction:", err)
+		return true
+	}
+
+	fmt.Printf("Identities: %d added, %d removed\n", added[0], removedIdentities)
+	fmt.Printf("Domains: %d added, %d removed\n", added[1], removedDomains)
+	fmt.Printf("Mappings: %d added, %d removed\n", added[2], removedMappings)
+	fmt.Println("Sync completed successfully!")
+	return false
+}
+
+// deleteBatch returns the number of rows each DELETE can remove when
+// every row binds perRow parameters, staying within the dialect's limit
+func deleteBatch(dialect database.Dialect, perRow int) int {
+	batch := dialect.MaxParams() / perRow
+	if batch > maxDeleteBatch {
+		batch = maxDeleteBatch
+	}
+	if batch < 1 {
+		batch = 1
+	}
+	return batch
+}
+
+// deleteValues deletes the rows of table whose column holds one of
+// values, batch rows at a time, and returns the number of rows deleted
+func deleteValues(tx *database.Tx, b *builder.Builder, table, column string, values []string, batch int) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(values); start += batch {
+		end := start + batch
+		if end > len(values) {
+			end = len(values)
+		}
+
+		args := make([]interface{}, 0, end-start)
+		for _, value := range values[start:end] {
+			args = append(args, value)
+		}
+
+		result, err := tx.ExecFrom(b.Delete(table).Where(builder.In(column, args...)))
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// deleteMappings deletes the given mappings, batch at a time, and returns
+// the number of rows deleted
+func deleteMappings(tx *database.Tx, b *builder.Builder, mappings []mapping, batch int) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(mappings); start += batch {
+		end := start + batch
+		if end > len(mappings) {
+			end = len(mappings)
+		}
+
+		conds := make([]builder.Cond, 0, end-start)
+		for _, m := range mappings[start:end] {
+			conds = append(conds, builder.And(
+				builder.Eq("identity", m.identity),
+				builder.Eq("domain_name", m.domain),
+			))
+		}
+
+		result, err := tx.ExecFrom(b.Delete("data_domain_identities").Where(builder.Or(conds...)))
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// planJSON is the -dry-run -output json document
+type planJSON struct {
+	Mode       string         `json:"mode"`
+	Identities nameChanges    `json:"identities"`
+	Domains    nameChanges    `json:"domains"`
+	Mappings   mappingChanges `json:"mappings"`
+	PerDomain  []domainTotals `json:"per_domain"`
+
+	// Blocked explains why -sync would refuse the plan
+	Blocked string `json:"blocked,omitempty"`
+
+	// Issues lists the problems found in security.list
+	Issues []issue `json:"issues"`
+}
+
+// nameChanges lists the identities or domains added and removed
+type nameChanges struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// mappingChanges lists the mappings added and removed
+type mappingChanges struct {
+	Add    []mappingJSON `json:"add"`
+	Remove []mappingJSON `json:"remove"`
+}
+
+// mappingJSON is a mapping in the JSON plan
+type mappingJSON struct {
+	Identity string `json:"identity"`
+	Domain   string `json:"domain"`
+}
+
+// domainTotals counts the mapping changes of one domain. Status is new,
+// removed or existing.
+type domainTotals struct {
+	Domain  string `json:"domain"`
+	Status  string `json:"status"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	After   int    `json:"after"`
+}
+
+// perDomain returns the totals of every domain the plan touches, sorted
+// by name. current holds the tables' state before the plan.
+func (p *changePlan) perDomain(current *securityList) []domainTotals {
+	totals := make(map[string]*domainTotals)
+	get := func(domain string) *domainTotals {
+		t, ok := totals[domain]
+		if !ok {
+			t = &domainTotals{Domain: domain, Status: "existing", After: len(current.mappings[domain])}
+			totals[domain] = t
+		}
+		return t
+	}
+
+	for _, domain := range p.addDomains {
+		get(domain).Status = "new"
+	}
+	for _, domain := range p.removeDomains {
+		get(domain).Status = "removed"
+	}
+	for _, m := range p.addMappings {
+		t := get(m.domain)
+		t.Added++
+		t.After++
+	}
+	for _, m := range p.removeMappings {
+		t := get(m.domain)
+		t.Removed++
+		t.After--
+	}
+
+	result := make([]domainTotals, 0, len(totals))
+	for _, t := range totals {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Domain < result[j].Domain })
+	return result
+}
+
+// droppingIssues returns the issues that dropped a line or a name from
+// the file. Unlike empty or duplicate identities, their rows may exist in
+// the tables and -sync would delete them.
+func (l *securityList) droppingIssues() []issue {
+	var dropping []issue
+	for _, is := range l.issues {
+		switch is.Kind {
+		case issueFieldCount, issueEmptyDomain, issueInvalidName:
+			dropping = append(dropping, is)
+		}
+	}
+	return dropping
+}
+
+// syncIssuesError explains why -sync refuses list, or returns nil when no
+// issue dropped anything that would be deleted
+func syncIssuesError(list *securityList) error {
+	dropping := list.droppingIssues()
+	if len(dropping) == 0 {
+		return nil
+	}
+	return fmt.Errorf("security.list has %d issue(s) that skip lines or names, which would be deleted; fix them before syncing", len(dropping))
+}
+
+// planOnly reads the current state, prints what the load, or -sync when
+// sync is set, would change and rolls back without writing. It reports
+// whether planning failed or -sync would refuse the plan.
+func planOnly(ctx context.Context, dbConn *database.DatabaseConnection, list *securityList, sync, prune bool, maxDelete float64, jsonOutput bool) bool {
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error starting transaction:", err)
+		return true
+	}
+	defer tx.Rollback()
+
+	current, err := readCurrentState(tx, builder.New(dbConn.Dialect()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading current state:", err)
+		return true
+	}
+
+	plan := newChangePlan(list, current, sync, prune)
+	mode := "load"
+	var blocked string
+	if sync {
+		mode = "sync"
+		if err := syncIssuesError(list); err != nil {
+			blocked = err.Error()
+		} else if err := plan.checkDeleteLimit(maxDelete); err != nil {
+			blocked = err.Error()
+		}
+	}
+	totals := plan.perDomain(current)
+
+	if jsonOutput {
+		doc := planJSON{
+			Mode:       mode,
+			Identities: nameChanges{Add: nonNil(plan.addIdentities), Remove: nonNil(plan.removeIdentities)},
+			Domains:    nameChanges{Add: nonNil(plan.addDomains), Remove: nonNil(plan.removeDomains)},
+			Mappings:   mappingChanges{Add: mappingsJSON(plan.addMappings), Remove: mappingsJSON(plan.removeMappings)},
+			PerDomain:  totals,
+			Blocked:    blocked,
+			Issues:     list.issues,
+		}
+		if doc.Issues == nil {
+			doc.Issues = []issue{}
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(doc); err != nil {
+			log.Printf("Failed to write JSON output: %v", err)
+			return true
+		}
+		return blocked != ""
+	}
+
+	fmt.Printf("Plan (%s, dry run):\n", mode)
+	printNames("Identities", plan.addIdentities, plan.removeIdentities)
+	printNames("Domains", plan.addDomains, plan.removeDomains)
+	fmt.Printf("Mappings: +%d -%d\n", len(plan.addMappings), len(plan.removeMappings))
+	for _, m := range plan.addMappings {
+		fmt.Printf("  + %s: %s\n", m.domain, m.identity)
+	}
+	for _, m := range plan.removeMappings {
+		fmt.Printf("  - %s: %s\n", m.domain, m.identity)
+	}
+
+	if len(totals) > 0 {
+		fmt.Println("Per domain:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  DOMAIN\tSTATUS\tADDED\tREMOVED\tAFTER")
+		for _, t := range totals {
+			fmt.Fprintf(w, "  %s\t%s\t%d\t%d\t%d\n", t.Domain, t.Status, t.Added, t.Removed, t.After)
+		}
+		w.Flush()
+	}
+
+	if blocked != "" {
+		fmt.Println("Sync would be refused:", blocked)
+	}
+	fmt.Println("Dry run; no changes were made.")
+	return blocked != ""
+}
+
+// printNames prints the identities or domains a plan adds and removes
+func printNames(title string, add, remove []string) {
+	fmt.Printf("%s: +%d -%d\n", title, len(add), len(remove))
+	for _, name := range add {
+		fmt.Printf("  + %s\n", name)
+	}
+	for _, name := range remove {
+		fmt.Printf("  - %s\n", name)
+	}
+}
+
+// nonNil returns names, or an empty slice so JSON shows [] rather than null
+func nonNil(names []string) []string {
+	if names == nil {
+		return []string{}
+	}
+	return names
+}
+
+// mappingsJSON converts mappings for the JSON plan
+func mappingsJSON(mappings []mapping) []mappingJSON {
+	result := make([]mappingJSON, len(mappings))
+	for i, m := range mappings {
+		result[i] = mappingJSON{Identity: m.identity, Domain: m.domain}
+	}
+	return result
 }
@@ -1,37 +1,154 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
 
 	"your_module_name/database"
 )
 
+// Exit codes of the ping command, one per failure class
+const (
+	exitOK      = 0 // Ping succeeded
+	exitUnknown = 1 // Any failure not listed below
+	exitConfig  = 2 // Invalid flags or configuration file
+	exitDNS     = 3 // Host could not be resolved
+	exitRefused = 4 // TCP connection refused
+	exitTLS     = 5 // TLS handshake or certificate failure
+	exitAuth    = 6 // Authentication failed
+	exitTimeout = 7 // Connect or ping timed out
+)
+
+// exitCodes maps failure classes to exit codes
+var exitCodes = map[string]int{
+	database.FailureConfig:  exitConfig,
+	database.FailureDNS:     exitDNS,
+	database.FailureRefused: exitRefused,
+	database.FailureTLS:     exitTLS,
+	database.FailureAuth:    exitAuth,
+	database.FailureTimeout: exitTimeout,
+}
+
+// exitUsage documents the exit codes in -h output
+const exitUsage = `
+Exit codes:
+  0  ping succeeded
+  1  unknown error
+  2  invalid flags or configuration
+  3  DNS resolution failed
+  4  TCP connection refused
+  5  TLS handshake or certificate failure
+  6  authentication failed
+  7  connect or ping timed out
+`
+
+// exitCode returns the exit code for err
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if code, ok := exitCodes[database.FailureClass(err)]; ok {
+		return code
+	}
+	return exitUnknown
+}
+
 func main() {
 	// Define command-line flags
-	configPath := flag.String("f", "config.yaml", "Path to the database configuration file")
+	var configPaths pathList
+	flag.Var(&configPaths, "f", "Path to a database configuration file, multi-database file or directory of configs; repeat to ping several (default config.yaml)")
 	pingFlag := flag.Bool("ping", false, "Test database connection")
+	count := flag.Int("count", 1, "Number of pings to send with -ping (0 pings until interrupted)")
+	interval := flag.Duration("interval", time.Second, "Wait between pings when -count is not 1")
+	output := flag.String("output", "text", "Ping output format: text or json")
+	diagnoseFlag := flag.Bool("diagnose", false, "Check the connection layer by layer and report where it fails")
+	workers := flag.Int("workers", 4, "Targets pinged concurrently when pinging several")
+	serveAddr := flag.String("serve", "", "Serve /healthz, /readyz and /metrics on this address, e.g. :8080")
+	readyCache := flag.Duration("ready-cache", 5*time.Second, "How long -serve reuses a readiness ping result")
+	waitFor := flag.Duration("wait", 0, "Wait up to this long for the database to become ready, retrying with backoff")
+	readyQuery := flag.String("ready-query", "", "Query that must succeed before -wait considers the database ready")
+	timeoutFlags.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitUsage)
+	}
 	flag.Parse()
 
 	// Validate that a config file path is provided
-	if *configPath == "" {
-		log.Fatal("Please provide a configuration file path using the -f flag")
+	if len(configPaths) == 0 {
+		configPaths = pathList{"config.yaml"}
+	}
+	configPath := configPaths[0]
+	if configPath == "" {
+		log.Print("Please provide a configuration file path using the -f flag")
+		os.Exit(exitConfig)
+	}
+	if *output != "text" && *output != "json" {
+		log.Printf("Unknown -output %q, expected text or json", *output)
+		os.Exit(exitConfig)
+	}
+
+	// Several files, a directory or a multi-database file ping every target
+	targets, err := database.LoadPingTargets(configPaths)
+	if len(configPaths) > 1 && err != nil {
+		log.Printf("Failed to load targets: %v", err)
+		os.Exit(exitConfig)
+	}
+	if err == nil && (len(configPaths) > 1 || len(targets) != 1 || targets[0].Name != configPath) {
+		if !*pingFlag || *diagnoseFlag || *count != 1 || *serveAddr != "" || *waitFor > 0 {
+			log.Print("Only a single -ping supports several targets")
+			os.Exit(exitConfig)
+		}
+		for _, target := range targets {
+			target.Config.Database.Timeouts = target.Config.Database.Timeouts.Override(timeoutFlags)
+		}
+		os.Exit(pingMany(targets, *workers, *output == "json"))
+	}
+
+	// Block until the database is ready, for container entrypoints
+	if *waitFor > 0 {
+		os.Exit(wait(configPath, *waitFor, *readyQuery))
+	}
+
+	// Serve health endpoints for as long as the process runs
+	if *serveAddr != "" {
+		os.Exit(serve(configPath, *serveAddr, *readyCache))
+	}
+
+	// Diagnose runs each connection layer on its own
+	if *diagnoseFlag {
+		os.Exit(diagnose(configPath, *output == "json"))
+	}
+
+	// Repeated pings reuse one connection and end with a summary
+	if *pingFlag && *count != 1 {
+		os.Exit(pingContinuously(configPath, *count, *interval, *output == "json"))
 	}
 
 	// If ping flag is set, only perform ping test
 	if *pingFlag {
-		fmt.Println("Testing database connection...")
-		err := database.PingDatabase(*configPath)
-		if err != nil {
-			log.Fatalf("Database connection test failed: %v", err)
-		}
-		fmt.Println("Database connection successful!")
-		return
+		os.Exit(pingOnce(configPath, *output == "json"))
 	}
 
 	// Regular database connection and query logic
-	dbConn, err := database.NewDatabaseConnection(*configPath)
+	config, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	dbConn, err := database.Connect(config, nil)
 	if err != nil {
 		log.Fatalf("Database connection failed: %v", err)
 	}
@@ -57,3 +174,353 @@ func main() {
 		fmt.Printf("ID: %d, Name: %s\n", id, name)
 	}
 }
+
+// timeoutFlags holds the -*-timeout flags, which override the timeouts
+// of every loaded config
+var timeoutFlags database.TimeoutsConfig
+
+// loadConfig reads the config file and applies the timeout flags
+func loadConfig(configPath string) (*database.Config, error) {
+	config, err := database.ReadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+	config.Database.Timeouts = config.Database.Timeouts.Override(timeoutFlags)
+	return config, nil
+}
+
+// pingLogger keeps library logs off stdout when it carries JSON output
+func pingLogger() zerolog.Logger {
+	return zerolog.New(os.Stderr).Level(zerolog.WarnLevel).With().Timestamp().Logger()
+}
+
+// pingOnce runs a single detailed ping and returns the exit code
+func pingOnce(configPath string, jsonOutput bool) int {
+	var logger *zerolog.Logger
+	if jsonOutput {
+		l := pingLogger()
+		logger = &l
+	} else {
+		fmt.Println("Testing database connection...")
+	}
+
+	var result database.PingResult
+	config, err := loadConfig(configPath)
+	if err != nil {
+		result = database.PingResult{Error: err.Error(), ErrorClass: database.FailureClass(err)}
+	} else {
+		result, err = database.PingConfig(config, logger)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return exitCode(err)
+	}
+	if err != nil {
+		log.Printf("Database connection test failed (%s): %v", result.ErrorClass, err)
+		return exitCode(err)
+	}
+	fmt.Println("Database connection successful!")
+	printPingResult(result)
+	return exitOK
+}
+
+// printJSON writes v to stdout as a single line of JSON
+func printJSON(v interface{}) {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		log.Printf("Failed to write JSON output: %v", err)
+	}
+}
+
+// printPingResult prints the details of a successful ping
+func printPingResult(result database.PingResult) {
+	fmt.Printf("  Driver:           %s\n", result.Driver)
+	fmt.Printf("  Server version:   %s\n", result.ServerVersion)
+	fmt.Printf("  Connect latency:  %v\n", result.ConnectLatency)
+	fmt.Printf("  Round trip:       %v\n", result.RoundTrip)
+	fmt.Printf("  Current user:     %s\n", result.CurrentUser)
+	fmt.Printf("  Current database: %s\n", result.CurrentDatabase)
+	fmt.Printf("  Current schema:   %s\n", result.CurrentSchema)
+	fmt.Printf("  TLS:              %t\n", result.TLS)
+	fmt.Printf("  Read-only:        %t\n", result.ReadOnly)
+	fmt.Printf("  Replica:          %t\n", result.Replica)
+	for _, warning := range result.Warnings {
+		fmt.Printf("  Warning:          %s\n", warning)
+	}
+}
+
+// probeLine is the JSON output for one probe in continuous mode
+type probeLine struct {
+	Seq        int           `json:"seq"`
+	Latency    time.Duration `json:"latency,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+}
+
+// summaryLine is the JSON output closing continuous mode
+type summaryLine struct {
+	Driver  string               `json:"driver"`
+	Target  string               `json:"target"`
+	Loss    float64              `json:"loss"`
+	Summary database.PingSummary `json:"summary"`
+}
+
+// pingContinuously pings the database count times (forever if count is
+// 0), printing one line per probe and a summary when done or interrupted.
+// Like ping(8), it fails only when no ping succeeded, returning the exit
+// code of the last error.
+func pingContinuously(configPath string, count int, interval time.Duration, jsonOutput bool) int {
+	var logger *zerolog.Logger
+	if jsonOutput {
+		l := pingLogger()
+		logger = &l
+	}
+	config, err := loadConfig(configPath)
+	var dbConn *database.DatabaseConnection
+	if err == nil {
+		dbConn, err = database.Connect(config, logger)
+	}
+	if err != nil {
+		if jsonOutput {
+			printJSON(probeLine{Error: err.Error(), ErrorClass: database.FailureClass(err)})
+		} else {
+			log.Printf("Database connection failed: %v", err)
+		}
+		return exitCode(err)
+	}
+	defer dbConn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	target := dbConn.Config.Database.Host
+	if dbConn.Config.Database.Driver == "sqlite3" {
+		target = dbConn.Config.Database.Filepath
+	} else if dbConn.Config.Database.Port != 0 {
+		target = fmt.Sprintf("%s:%d", target, dbConn.Config.Database.Port)
+	}
+	if !jsonOutput {
+		fmt.Printf("PING %s %s\n", dbConn.Config.Database.Driver, target)
+	}
+
+	var series database.PingSeries
+	var lastErr error
+	for seq := 1; count == 0 || seq <= count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Each probe is bounded by the configured ping timeout
+		latency, err := dbConn.Ping(ctx)
+		if ctx.Err() != nil {
+			// Interrupted mid-probe; don't count it as a failure
+			break
+		}
+
+		series.Add(latency, err)
+		switch {
+		case jsonOutput && err != nil:
+			printJSON(probeLine{Seq: seq, Error: err.Error(), ErrorClass: database.FailureClass(err)})
+		case jsonOutput:
+			printJSON(probeLine{Seq: seq, Latency: latency})
+		case err != nil:
+			fmt.Printf("seq=%d error: %v\n", seq, err)
+		default:
+			fmt.Printf("seq=%d time=%v\n", seq, latency)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	summary := series.Summary()
+	code := exitOK
+	if summary.Sent > 0 && summary.Sent == summary.Failed {
+		code = exitCode(lastErr)
+	}
+
+	if jsonOutput {
+		printJSON(summaryLine{
+			Driver:  dbConn.Config.Database.Driver,
+			Target:  target,
+			Loss:    summary.Loss(),
+			Summary: summary,
+		})
+		return code
+	}
+
+	fmt.Printf("\n--- %s %s ping statistics ---\n", dbConn.Config.Database.Driver, target)
+	fmt.Printf("%d sent, %d failed, %.1f%% loss\n", summary.Sent, summary.Failed, summary.Loss())
+	if summary.Sent > summary.Failed {
+		fmt.Printf("latency min/avg/max/p95 = %v/%v/%v/%v\n", summary.Min, summary.Avg, summary.Max, summary.P95)
+	}
+
+	return code
+}
+
+// diagnose prints the outcome of each diagnostic step and returns the
+// exit code of the failed step, if any
+func diagnose(configPath string, jsonOutput bool) int {
+	steps := database.Diagnose(context.Background(), configPath, timeoutFlags)
+
+	code := exitOK
+	for _, step := range steps {
+		if step.Status == database.StepFail {
+			code = exitUnknown
+			if c, ok := exitCodes[step.ErrorClass]; ok {
+				code = c
+			}
+		}
+	}
+
+	if jsonOutput {
+		printJSON(steps)
+		return code
+	}
+
+	for _, step := range steps {
+		line := step.Detail
+		if step.Error != "" {
+			line = step.Error
+		}
+		fmt.Printf("[%s] %-7s %10v  %s\n", strings.ToUpper(step.Status), step.Name, step.Duration.Round(time.Microsecond), line)
+		if step.Hint != "" {
+			fmt.Printf("       %-7s %10s  hint: %s\n", "", "", step.Hint)
+		}
+	}
+	return code
+}
+
+// pathList is a flag that can be repeated to collect several paths
+type pathList []string
+
+func (p *pathList) String() string { return strings.Join(*p, ",") }
+
+func (p *pathList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// pingMany pings every target concurrently and prints one row per
+// target. It fails if any target fails, with that target's exit code
+// when all failures share a class.
+func pingMany(targets []database.PingTarget, workers int, jsonOutput bool) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := database.PingTargets(ctx, targets, workers, pingLogger())
+
+	code := exitOK
+	for _, result := range results {
+		if result.ErrorClass == "" {
+			continue
+		}
+		c, ok := exitCodes[result.ErrorClass]
+		if !ok || (code != exitOK && code != c) {
+			c = exitUnknown
+		}
+		code = c
+	}
+
+	if jsonOutput {
+		printJSON(results)
+		return code
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tDRIVER\tSTATUS\tCONNECT\tROUND TRIP\tVERSION\tERROR")
+	for _, r := range results {
+		status, connect, roundTrip := "ok", r.ConnectLatency.Round(time.Microsecond).String(), r.RoundTrip.Round(time.Microsecond).String()
+		if r.Error != "" {
+			status, connect, roundTrip = "FAIL ("+r.ErrorClass+")", "-", "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Target, r.Driver, status, connect, roundTrip, r.ServerVersion, r.Error)
+	}
+	w.Flush()
+	return code
+}
+
+// serve keeps a connection open and serves health endpoints on addr until
+// interrupted
+func serve(configPath, addr string, readyCache time.Duration) int {
+	config, err := loadConfig(configPath)
+	var dbConn *database.DatabaseConnection
+	if err == nil {
+		dbConn, err = database.Connect(config, nil)
+	}
+	if err != nil {
+		log.Printf("Database connection failed: %v", err)
+		return exitCode(err)
+	}
+	defer dbConn.Close()
+
+	checker := database.NewHealthChecker(dbConn, readyCache, 0)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           checker.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	dbConn.Logger.Info().Str("addr", addr).Msg("Serving health endpoints")
+
+	select {
+	case err := <-errs:
+		log.Printf("Health server failed: %v", err)
+		return exitUnknown
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Health server shutdown failed: %v", err)
+	}
+	return exitOK
+}
+
+// wait blocks until the database accepts connections and passes
+// readyQuery, printing each failed attempt, and returns the exit code of
+// the last error on timeout
+func wait(configPath string, timeout time.Duration, readyQuery string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Printf("Wait failed: %v\n", err)
+		return exitCode(err)
+	}
+
+	logger := pingLogger()
+	start := time.Now()
+	attempts := 1
+	dbConn, err := database.WaitForDatabase(ctx, config, database.WaitOptions{
+		ReadyQuery: readyQuery,
+		Logger:     &logger,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			attempts = attempt + 1
+			fmt.Printf("Attempt %d failed (%s): %v; retrying in %v\n", attempt, database.FailureClass(err), err, delay)
+		},
+	})
+	if err != nil {
+		fmt.Printf("Wait failed after %v: %v\n", time.Since(start).Round(time.Millisecond), err)
+		return exitCode(err)
+	}
+	dbConn.Close()
+
+	fmt.Printf("Database ready after %d attempt(s) in %v\n", attempts, time.Since(start).Round(time.Millisecond))
+	return exitOK
+}
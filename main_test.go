@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"your_module_path/database" // Replace with the actual path of your `database` package
+)
+
+// loaderSchema creates the tables the loader writes to. failTable, when
+// set, is left out so the step inserting into it fails.
+func loaderSchema(failTable string) []string {
+	tables := map[string]string{
+		"technical_identities":   "CREATE TABLE technical_identities (identity TEXT PRIMARY KEY)",
+		"data_domains":           "CREATE TABLE data_domains (domain_name TEXT PRIMARY KEY)",
+		"data_domain_identities": "CREATE TABLE data_domain_identities (identity TEXT, domain_name TEXT, PRIMARY KEY (identity, domain_name))",
+	}
+	var statements []string
+	for _, table := range []string{"technical_identities", "data_domains", "data_domain_identities"} {
+		if table != failTable {
+			statements = append(statements, tables[table])
+		}
+	}
+	return statements
+}
+
+// writeLoaderConfig writes a SQLite config for a database in dir and
+// returns its path
+func writeLoaderConfig(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.yaml")
+	config := "database:\n" +
+		"  driver: sqlite3\n" +
+		"  filepath: " + filepath.Join(dir, "loader.db") + "\n" +
+		"  log_level: error\n" +
+		"  pool:\n" +
+		"    max_open_conns: 1\n" +
+		"    conn_max_lifetime: 5m\n" +
+		"    conn_max_idle_time: 1m\n"
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+// openLoaderDB connects to the database described by the config in dir,
+// creating the loader's tables except failTable
+func openLoaderDB(t *testing.T, dir, failTable string) *database.DatabaseConnection {
+	t.Helper()
+	config, err := database.ReadConfig(writeLoaderConfig(t, dir))
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	logger := zerolog.Nop()
+	dbConn, err := database.Connect(config, &logger)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	for _, statement := range loaderSchema(failTable) {
+		if _, err := dbConn.DB.Exec(statement); err != nil {
+			t.Fatalf("create schema: %v", err)
+		}
+	}
+	return dbConn
+}
+
+// listOf returns a security list holding mappings and the identities
+// and domains they reference
+func listOf(mappings ...mapping) *securityList {
+	list := newSecurityList()
+	for _, m := range mappings {
+		list.identities[m.identity] = struct{}{}
+		list.domains[m.domain] = struct{}{}
+		list.addMapping(m.domain, m.identity)
+	}
+	return list
+}
+
+// testList returns a security list with two domains sharing an identity
+func testList() *securityList {
+	return listOf(mapping{"svc_a", "sales"}, mapping{"svc_b", "sales"}, mapping{"svc_a", "hr"})
+}
+
+// countRows returns the number of rows in table, or -1 when it doesn't
+// exist
+func countRows(t *testing.T, dbConn *database.DatabaseConnection, table string) int {
+	t.Helper()
+	var n int
+	if err := dbConn.DB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		return -1
+	}
+	return n
+}
+
+func TestLoadFailureHandling(t *testing.T) {
+	tests := []struct {
+		name      string
+		load      func(context.Context, *database.DatabaseConnection, []loadStep) bool
+		failTable string
+		failed    bool
+
+		// Rows left in technical_identities and data_domains
+		identities int
+		domains    int
+	}{
+		{"all succeeds", loadAll, "", false, 2, 2},
+		{"all rolls back when mappings fail", loadAll, "data_domain_identities", true, 0, 0},
+		{"all rolls back when domains fail", loadAll, "data_domains", true, 0, -1},
+		{"each succeeds", loadEach, "", false, 2, 2},
+		{"each keeps earlier steps when mappings fail", loadEach, "data_domain_identities", true, 2, 2},
+		{"each continues after domains fail", loadEach, "data_domains", true, 2, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConn := openLoaderDB(t, t.TempDir(), tt.failTable)
+
+			if failed := tt.load(context.Background(), dbConn, testList().steps()); failed != tt.failed {
+				t.Fatalf("failed = %v, want %v", failed, tt.failed)
+			}
+			if got := countRows(t, dbConn, "technical_identities"); got != tt.identities {
+				t.Errorf("technical_identities has %d rows, want %d", got, tt.identities)
+			}
+			if got := countRows(t, dbConn, "data_domains"); got != tt.domains {
+				t.Errorf("data_domains has %d rows, want %d", got, tt.domains)
+			}
+		})
+	}
+}
+
+// TestMain runs the loader's main instead of the tests when
+// LOADER_TEST_ARGS is set, so tests can check its exit status
+func TestMain(m *testing.M) {
+	if args := os.Getenv("LOADER_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"loader"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// loaderCommand returns a command running the loader with args in a
+// subprocess
+func loaderCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "LOADER_TEST_ARGS="+strings.Join(args, "\n"))
+	return cmd
+}
+
+// exitStatus returns the exit status of a loader subprocess that
+// finished with err
+func exitStatus(t *testing.T, err error) int {
+	t.Helper()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		t.Fatalf("run loader: %v", err)
+		return 0
+	}
+}
+
+// runLoader runs the loader with args in a subprocess and returns its
+// combined output and exit status
+func runLoader(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	out, err := loaderCommand(args...).CombinedOutput()
+	return string(out), exitStatus(t, err)
+}
+
+// writeList writes a security.list with content to dir and returns its
+// path
+func writeList(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "security.list")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write security.list: %v", err)
+	}
+	return path
+}
+
+// TestMainExitsNonZeroOnFailure runs the loader against a database
+// missing the mappings table and checks that it exits with status 1 and
+// leaves the other tables empty
+func TestMainExitsNonZeroOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	dbConn := openLoaderDB(t, dir, "data_domain_identities")
+	listPath := writeList(t, dir, "sales,svc_a\nhr,svc_b\n")
+
+	out, status := runLoader(t, "-config", filepath.Join(dir, "config.yaml"), "-file", listPath)
+	if status != 1 {
+		t.Fatalf("loader exited with status %d, want 1; output:\n%s", status, out)
+	}
+	if !strings.Contains(out, "Load rolled back") {
+		t.Errorf("loader failed without rolling back; output:\n%s", out)
+	}
+	if got := countRows(t, dbConn, "technical_identities"); got != 0 {
+		t.Errorf("technical_identities has %d rows after a failed load, want 0", got)
+	}
+	if got := countRows(t, dbConn, "data_domains"); got != 0 {
+		t.Errorf("data_domains has %d rows after a failed load, want 0", got)
+	}
+}
+
+func TestNewChangePlan(t *testing.T) {
+	file := listOf(mapping{"svc_a", "sales"}, mapping{"svc_b", "sales"})
+	current := listOf(mapping{"svc_a", "sales"}, mapping{"svc_c", "hr"})
+
+	tests := []struct {
+		name        string
+		sync, prune bool
+		want        changePlan
+	}{
+		{"load", false, false, changePlan{
+			addIdentities: []string{"svc_b"},
+			addMappings:   []mapping{{"svc_b", "sales"}},
+		}},
+		{"sync", true, false, changePlan{
+			addIdentities:  []string{"svc_b"},
+			addMappings:    []mapping{{"svc_b", "sales"}},
+			removeMappings: []mapping{{"svc_c", "hr"}},
+		}},
+		{"sync and prune", true, true, changePlan{
+			addIdentities:    []string{"svc_b"},
+			removeIdentities: []string{"svc_c"},
+			removeDomains:    []string{"hr"},
+			addMappings:      []mapping{{"svc_b", "sales"}},
+			removeMappings:   []mapping{{"svc_c", "hr"}},
+		}},
+		{"prune without sync", false, true, changePlan{
+			addIdentities: []string{"svc_b"},
+			addMappings:   []mapping{{"svc_b", "sales"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.identities, tt.want.domains, tt.want.mappings = 2, 2, 2
+			if got := newChangePlan(file, current, tt.sync, tt.prune); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("newChangePlan() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDeleteLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		plan       changePlan
+		maxPercent float64
+		wantErr    bool
+	}{
+		{"nothing removed", changePlan{mappings: 10}, 0, false},
+		{"empty tables", changePlan{}, 0, false},
+		{"at the limit", changePlan{removeMappings: make([]mapping, 1), mappings: 10}, 10, false},
+		{"mappings over the limit", changePlan{removeMappings: make([]mapping, 2), mappings: 10}, 10, true},
+		{"identities over the limit", changePlan{removeIdentities: make([]string, 3), identities: 4, mappings: 10}, 50, true},
+		{"domains over the limit", changePlan{removeDomains: make([]string, 1), domains: 1}, 99, true},
+		{"everything allowed", changePlan{removeDomains: make([]string, 1), domains: 1}, 100, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.plan.checkDeleteLimit(tt.maxPercent); (err != nil) != tt.wantErr {
+				t.Errorf("checkDeleteLimit(%v) = %v, want error %v", tt.maxPercent, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// paramLimitDialect overrides the bind parameter limit of a dialect
+type paramLimitDialect struct {
+	database.Dialect
+	maxParams int
+}
+
+func (d paramLimitDialect) MaxParams() int { return d.maxParams }
+
+func TestDeleteBatch(t *testing.T) {
+	tests := []struct {
+		maxParams, perRow, want int
+	}{
+		{65535, 2, maxDeleteBatch},
+		{999, 1, maxDeleteBatch},
+		{999, 2, 499},
+		{1, 2, 1},
+	}
+
+	for _, tt := range tests {
+		if got := deleteBatch(paramLimitDialect{maxParams: tt.maxParams}, tt.perRow); got != tt.want {
+			t.Errorf("deleteBatch(%d params, %d per row) = %d, want %d", tt.maxParams, tt.perRow, got, tt.want)
+		}
+	}
+}
+
+func TestSyncIssuesError(t *testing.T) {
+	tests := []struct {
+		kind    string
+		refused bool
+	}{
+		{issueFieldCount, true},
+		{issueEmptyDomain, true},
+		{issueInvalidName, true},
+		{issueEmptyIdentity, false},
+		{issueDuplicateIdentity, false},
+	}
+
+	for _, tt := range tests {
+		list := testList()
+		list.addIssue(3, tt.kind, "test issue")
+		if err := syncIssuesError(list); (err != nil) != tt.refused {
+			t.Errorf("%s: syncIssuesError() = %v, want refused %v", tt.kind, err, tt.refused)
+		}
+	}
+}
+
+func TestReadSecurityList(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		pattern  string
+		issues   []issue
+		mappings int
+	}{
+		{"clean", "sales,svc_a:svc_b\nhr,svc_a\n", "", nil, 3},
+		{"wrong field count", "sales,svc_a\nhr\nops,svc_b,extra\n", "",
+			[]issue{{Line: 2, Kind: issueFieldCount}, {Line: 3, Kind: issueFieldCount}}, 1},
+		{"empty domain", "sales,svc_a\n ,svc_b\n", "",
+			[]issue{{Line: 2, Kind: issueEmptyDomain}}, 1},
+		{"empty identity", "sales,svc_a::svc_b\nhr,\n", "",
+			[]issue{{Line: 1, Kind: issueEmptyIdentity}, {Line: 2, Kind: issueEmptyIdentity}}, 2},
+		{"duplicate identity within a domain", "sales,svc_a:svc_a\nhr,svc_a\nsales,svc_a\n", "",
+			[]issue{{Line: 1, Kind: issueDuplicateIdentity}, {Line: 3, Kind: issueDuplicateIdentity}}, 2},
+		{"name pattern mismatches", "sales,svc_a:SVC B\nHR Team,svc_b\n", "^[a-z_]+$",
+			[]issue{{Line: 1, Kind: issueInvalidName}, {Line: 2, Kind: issueInvalidName}}, 1},
+		{"line numbers count quoted newlines", "\"sales\",\"svc_a:\nsvc_b\"\n,svc_c\n", "",
+			[]issue{{Line: 3, Kind: issueEmptyDomain}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pattern *regexp.Regexp
+			if tt.pattern != "" {
+				pattern = regexp.MustCompile(tt.pattern)
+			}
+			list, err := readSecurityList(writeList(t, t.TempDir(), tt.content), pattern)
+			if err != nil {
+				t.Fatalf("readSecurityList: %v", err)
+			}
+
+			var got []issue
+			for _, i := range list.issues {
+				got = append(got, issue{Line: i.Line, Kind: i.Kind})
+			}
+			if !reflect.DeepEqual(got, tt.issues) {
+				t.Errorf("issues = %+v, want %+v", got, tt.issues)
+			}
+			if got := list.mappingCount(); got != tt.mappings {
+				t.Errorf("loaded %d mappings, want %d", got, tt.mappings)
+			}
+		})
+	}
+}
+
+// TestStrictFailsOnIssues checks that -strict turns security.list issues
+// into a failure that loads nothing, while the same file loads without it
+func TestStrictFailsOnIssues(t *testing.T) {
+	dir := t.TempDir()
+	dbConn := openLoaderDB(t, dir, "")
+	config := filepath.Join(dir, "config.yaml")
+	listPath := writeList(t, dir, "sales,svc_a\nhr,svc_b:svc_b\n")
+
+	out, status := runLoader(t, "-config", config, "-file", listPath, "-strict")
+	if status != 1 {
+		t.Fatalf("loader -strict exited with status %d, want 1; output:\n%s", status, out)
+	}
+	if !strings.Contains(out, "-strict is set") {
+		t.Errorf("loader -strict failed for another reason; output:\n%s", out)
+	}
+	if got := countRows(t, dbConn, "technical_identities"); got != 0 {
+		t.Errorf("technical_identities has %d rows after -strict aborted, want 0", got)
+	}
+
+	if out, status := runLoader(t, "-config", config, "-file", listPath); status != 0 {
+		t.Fatalf("loader exited with status %d without -strict, want 0; output:\n%s", status, out)
+	}
+	if got := countRows(t, dbConn, "technical_identities"); got != 2 {
+		t.Errorf("technical_identities has %d rows without -strict, want 2", got)
+	}
+}
+
+// TestJSONPlan decodes the plan written by -dry-run -output json and
+// checks its lists and per-domain totals
+func TestJSONPlan(t *testing.T) {
+	dir := t.TempDir()
+	dbConn := openLoaderDB(t, dir, "")
+	seed := []string{
+		"INSERT INTO technical_identities (identity) VALUES ('svc_a'), ('svc_c')",
+		"INSERT INTO data_domains (domain_name) VALUES ('sales'), ('hr')",
+		"INSERT INTO data_domain_identities (identity, domain_name) VALUES ('svc_a', 'sales'), ('svc_c', 'sales'), ('svc_c', 'hr')",
+	}
+	for _, statement := range seed {
+		if _, err := dbConn.DB.Exec(statement); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	listPath := writeList(t, dir, "sales,svc_a:svc_b\nops,svc_b\n")
+	args := []string{"-config", filepath.Join(dir, "config.yaml"), "-file", listPath,
+		"-dry-run", "-output", "json", "-sync", "-prune"}
+
+	tests := []struct {
+		name      string
+		maxDelete string
+		status    int
+		blocked   bool
+	}{
+		{"allowed", "100", 0, false},
+		{"over the delete limit", "10", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr strings.Builder
+			cmd := loaderCommand(append(args, "-max-delete", tt.maxDelete)...)
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			if status := exitStatus(t, err); status != tt.status {
+				t.Fatalf("loader exited with status %d, want %d; stderr:\n%s", status, tt.status, stderr.String())
+			}
+
+			var got planJSON
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("decode plan: %v\n%s", err, out)
+			}
+			if got.Mode != "sync" {
+				t.Errorf("mode = %q, want sync", got.Mode)
+			}
+			if (got.Blocked != "") != tt.blocked {
+				t.Errorf("blocked = %q, want blocked %v", got.Blocked, tt.blocked)
+			}
+			if want := (nameChanges{Add: []string{"svc_b"}, Remove: []string{"svc_c"}}); !reflect.DeepEqual(got.Identities, want) {
+				t.Errorf("identities = %+v, want %+v", got.Identities, want)
+			}
+			if want := (nameChanges{Add: []string{"ops"}, Remove: []string{"hr"}}); !reflect.DeepEqual(got.Domains, want) {
+				t.Errorf("domains = %+v, want %+v", got.Domains, want)
+			}
+			wantMappings := mappingChanges{
+				Add:    []mappingJSON{{"svc_b", "ops"}, {"svc_b", "sales"}},
+				Remove: []mappingJSON{{"svc_c", "hr"}, {"svc_c", "sales"}},
+			}
+			if !reflect.DeepEqual(got.Mappings, wantMappings) {
+				t.Errorf("mappings = %+v, want %+v", got.Mappings, wantMappings)
+			}
+			wantTotals := []domainTotals{
+				{Domain: "hr", Status: "removed", Removed: 1, After: 0},
+				{Domain: "ops", Status: "new", Added: 1, After: 1},
+				{Domain: "sales", Status: "existing", Added: 1, Removed: 1, After: 2},
+			}
+			if !reflect.DeepEqual(got.PerDomain, wantTotals) {
+				t.Errorf("per_domain = %+v, want %+v", got.PerDomain, wantTotals)
+			}
+			if len(got.Issues) != 0 {
+				t.Errorf("issues = %+v, want none", got.Issues)
+			}
+		})
+	}
+}
@@ -2,27 +2,132 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"syscall"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+// Failure classes reported in PingResult.ErrorClass
+const (
+	FailureConfig  = "config"
+	FailureDNS     = "dns"
+	FailureRefused = "tcp_refused"
+	FailureTLS     = "tls"
+	FailureAuth    = "auth"
+	FailureTimeout = "timeout"
+	FailureUnknown = "unknown"
 )
 
-// PingDatabase tests the database connection and returns a detailed result
-func PingDatabase(configPath string) error {
+// PingResult describes a connection test
+type PingResult struct {
+	Target         string        `json:"target,omitempty"`
+	Driver         string        `json:"driver"`
+	Host           string        `json:"host,omitempty"`
+	Database       string        `json:"database,omitempty"`
+	ConnectLatency time.Duration `json:"connect_latency"`
+	RoundTrip      time.Duration `json:"round_trip"`
+
+	ServerVersion   string `json:"server_version,omitempty"`
+	CurrentUser     string `json:"current_user,omitempty"`
+	CurrentDatabase string `json:"current_database,omitempty"`
+	CurrentSchema   string `json:"current_schema,omitempty"`
+	TLS             bool   `json:"tls"`
+	ReadOnly        bool   `json:"read_only"`
+	Replica         bool   `json:"replica"`
+
+	// Warnings lists server properties that couldn't be read, for example
+	// because the user lacks access to a system view
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Error and ErrorClass describe why the test failed
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// PingDatabase tests the database connection and returns a detailed result.
+// The result is filled in as far as the test got, so it carries the
+// connect latency and the failure class even when the ping itself fails.
+func PingDatabase(configPath string) (PingResult, error) {
+	return pingConfig(configPath, nil)
+}
+
+// PingDatabaseWithLogger is PingDatabase writing to logger instead of the
+// logger described by the config
+func PingDatabaseWithLogger(configPath string, logger zerolog.Logger) (PingResult, error) {
+	return pingConfig(configPath, &logger)
+}
+
+// pingConfig implements PingDatabase, using logger when it is not nil
+func pingConfig(configPath string, logger *zerolog.Logger) (PingResult, error) {
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		err = fmt.Errorf("error reading config: %w", err)
+		return PingResult{Error: err.Error(), ErrorClass: FailureClass(err)}, err
+	}
+	return PingConfig(config, logger)
+}
+
+// PingConfig is PingDatabase for an already loaded config, such as one
+// read with ReadConfig and adjusted by the caller. It writes to logger
+// when not nil, otherwise to the logger the config describes.
+func PingConfig(config *Config, logger *zerolog.Logger) (PingResult, error) {
+	return PingConfigContext(context.Background(), config, logger)
+}
+
+// PingConfigContext is like PingConfig, but gives up when ctx is done
+func PingConfigContext(ctx context.Context, config *Config, logger *zerolog.Logger) (PingResult, error) {
+	result, err := runPing(ctx, config, logger)
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorClass = FailureClass(err)
+	}
+	return result, err
+}
+
+// runPing connects, pings and reads the server properties
+func runPing(ctx context.Context, config *Config, logger *zerolog.Logger) (PingResult, error) {
+	result := PingResult{
+		Driver:   config.Database.Driver,
+		Host:     config.Database.Host,
+		Database: config.Database.DBName,
+	}
+	if config.Database.Driver == "sqlite3" {
+		result.Database = config.Database.Filepath
+	}
+
 	// Create a new database connection
-	dbConn, err := NewDatabaseConnection(configPath)
+	start := time.Now()
+	dbConn, err := ConnectContext(ctx, config, logger)
+	result.ConnectLatency = time.Since(start)
 	if err != nil {
-		return fmt.Errorf("failed to create database connection: %v", err)
+		return result, fmt.Errorf("failed to create database connection: %w", err)
 	}
 	defer dbConn.Close()
 
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Bound the ping and the server queries by the ping timeout
+	ctx, cancel := withTimeout(ctx, dbConn.timeouts.ping)
 	defer cancel()
 
+	// Pin one connection so the session properties below describe it
+	conn, err := dbConn.DB.Conn(ctx)
+	if err != nil {
+		return result, fmt.Errorf("database ping failed: %w", wrapError(err))
+	}
+	defer conn.Close()
+
 	// Ping the database
-	start := time.Now()
-	err = dbConn.DB.PingContext(ctx)
-	duration := time.Since(start)
+	start = time.Now()
+	err = conn.PingContext(ctx)
+	result.RoundTrip = time.Since(start)
 
 	if err != nil {
 		dbConn.Logger.Error().
@@ -30,25 +135,187 @@ func PingDatabase(configPath string) error {
 			Str("driver", dbConn.Config.Database.Driver).
 			Str("host", dbConn.Config.Database.Host).
 			Str("database", dbConn.Config.Database.DBName).
-			Dur("ping_duration", duration).
+			Dur("ping_duration", result.RoundTrip).
 			Msg("Database ping failed")
-		return fmt.Errorf("database ping failed: %v", err)
+		return result, fmt.Errorf("database ping failed: %w", wrapError(err))
 	}
 
+	readServerInfo(ctx, conn, dbConn.dialect.ServerInfoQueries(), &result)
+
 	// Log successful connection
 	dbConn.Logger.Info().
 		Str("driver", dbConn.Config.Database.Driver).
 		Str("host", dbConn.Config.Database.Host).
 		Str("database", dbConn.Config.Database.DBName).
-		Dur("ping_duration", duration).
+		Dur("connect_duration", result.ConnectLatency).
+		Dur("ping_duration", result.RoundTrip).
+		Str("server_version", result.ServerVersion).
 		Msg("Database connection successful")
 
-	return nil
+	return result, nil
+}
+
+// readServerInfo runs queries on conn and stores the values in result.
+// Properties that can't be read are reported as warnings rather than
+// failing the ping.
+func readServerInfo(ctx context.Context, conn *sql.Conn, queries ServerInfoQueries, result *PingResult) {
+	textProps := []struct {
+		name  string
+		query string
+		dest  *string
+	}{
+		{"server_version", queries.Version, &result.ServerVersion},
+		{"current_user", queries.User, &result.CurrentUser},
+		{"current_database", queries.Database, &result.CurrentDatabase},
+		{"current_schema", queries.Schema, &result.CurrentSchema},
+	}
+	for _, s := range textProps {
+		if s.query == "" {
+			continue
+		}
+		var value sql.NullString
+		if err := conn.QueryRowContext(ctx, s.query).Scan(&value); err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %v", s.name, err))
+			continue
+		}
+		*s.dest = value.String
+	}
+
+	boolProps := []struct {
+		name  string
+		query string
+		dest  *bool
+	}{
+		{"tls", queries.TLS, &result.TLS},
+		{"read_only", queries.ReadOnly, &result.ReadOnly},
+		{"replica", queries.Replica, &result.Replica},
+	}
+	for _, b := range boolProps {
+		if b.query == "" {
+			continue
+		}
+		var value sql.NullBool
+		if err := conn.QueryRowContext(ctx, b.query).Scan(&value); err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %v", b.name, err))
+			continue
+		}
+		*b.dest = value.Bool
+	}
+}
+
+// FailureClass names the layer at which err occurred: FailureConfig,
+// FailureDNS, FailureRefused, FailureTLS, FailureAuth, FailureTimeout or
+// FailureUnknown. It returns "" for a nil error.
+func FailureClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(err, ErrConfig):
+		return FailureConfig
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.Is(err, pq.ErrSSLNotSupported),
+		errors.Is(err, pq.ErrSSLKeyHasWorldPermissions),
+		errors.As(err, &recordErr),
+		errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidErr):
+		return FailureTLS
+	case errors.Is(err, ErrAuth):
+		return FailureAuth
+	case errors.Is(err, ErrTimeout):
+		return FailureTimeout
+	}
+	return FailureUnknown
+}
+
+// Ping sends a single ping over an existing connection and returns its
+// round-trip time. It gives up after the ping timeout when ctx has no
+// deadline.
+func (dc *DatabaseConnection) Ping(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.ping)
+	defer cancel()
+
+	start := time.Now()
+	err := dc.DB.PingContext(ctx)
+	return time.Since(start), wrapError(err)
+}
+
+// PingSummary aggregates a series of pings; latencies cover successful
+// pings only
+type PingSummary struct {
+	Sent   int           `json:"sent"`
+	Failed int           `json:"failed"`
+	Min    time.Duration `json:"min"`
+	Avg    time.Duration `json:"avg"`
+	Max    time.Duration `json:"max"`
+	P95    time.Duration `json:"p95"`
+}
+
+// Loss returns the percentage of failed pings
+func (s PingSummary) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Failed) * 100 / float64(s.Sent)
+}
+
+// PingSeries records the outcome of repeated pings
+type PingSeries struct {
+	sent      int
+	failed    int
+	latencies []time.Duration
+}
+
+// Add records one ping
+func (s *PingSeries) Add(latency time.Duration, err error) {
+	s.sent++
+	if err != nil {
+		s.failed++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+}
+
+// Summary returns the statistics of the pings recorded so far. P95 uses
+// the nearest-rank method.
+func (s *PingSeries) Summary() PingSummary {
+	summary := PingSummary{Sent: s.sent, Failed: s.failed}
+	if len(s.latencies) == 0 {
+		return summary
+	}
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+	rank := (95*len(sorted) + 99) / 100
+
+	summary.Min = sorted[0]
+	summary.Max = sorted[len(sorted)-1]
+	summary.Avg = total / time.Duration(len(sorted))
+	summary.P95 = sorted[rank-1]
+	return summary
 }
 
 // TestDatabaseConnection is a wrapper for PingDatabase that can be used in tests
 func TestDatabaseConnection(configPath string) (bool, error) {
-	err := PingDatabase(configPath)
+	_, err := PingDatabase(configPath)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// PingTarget is one database to ping, loaded from a config file
+type PingTarget struct {
+	Name   string
+	Config *Config
+}
+
+// LoadPingTargets expands paths into ping targets. A path may be a
+// regular config file, a multi-database config file listing several
+// databases under a top-level databases: key, or a directory whose
+// .yaml and .yml files are loaded in name order. Targets from regular
+// files are named by their path, entries of multi-database files by
+// "path:entry".
+func LoadPingTargets(paths []string) ([]PingTarget, error) {
+	var targets []PingTarget
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, configError(err)
+		}
+
+		files := []string{path}
+		if info.IsDir() {
+			if files, err = configFiles(path); err != nil {
+				return nil, configError(err)
+			}
+		}
+
+		for _, file := range files {
+			loaded, err := loadTargetFile(file)
+			if err != nil {
+				return nil, configError(fmt.Errorf("%s: %v", file, err))
+			}
+			targets = append(targets, loaded...)
+		}
+	}
+	return targets, nil
+}
+
+// configFiles returns the YAML files in dir, sorted by name
+func configFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .yaml or .yml files in %s", dir)
+	}
+	return files, nil
+}
+
+// loadTargetFile reads a regular or multi-database config file
+func loadTargetFile(path string) ([]PingTarget, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Database  yaml.Node            `yaml:"database"`
+		Databases map[string]yaml.Node `yaml:"databases"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	if len(file.Databases) == 0 {
+		if file.Database.IsZero() {
+			return nil, errors.New("no database or databases section")
+		}
+		config, err := parseConfig(data)
+		if err != nil {
+			return nil, err
+		}
+		return []PingTarget{{Name: path, Config: config}}, nil
+	}
+
+	names := make([]string, 0, len(file.Databases))
+	for name := range file.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	targets := make([]PingTarget, 0, len(names))
+	for _, name := range names {
+		node := file.Databases[name]
+		var config Config
+		if err := node.Decode(&config.Database); err != nil {
+			return nil, fmt.Errorf("database %s: %v", name, err)
+		}
+		targets = append(targets, PingTarget{Name: path + ":" + name, Config: &config})
+	}
+	return targets, nil
+}
+
+// PingTargets pings every target using at most workers concurrent
+// connections and returns the results in target order. Connections log
+// to logger rather than to the loggers their configs describe, so
+// concurrent output doesn't interleave on stdout.
+func PingTargets(ctx context.Context, targets []PingTarget, workers int, logger zerolog.Logger) []PingResult {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	results := make([]PingResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(targets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				target := targets[i]
+				targetLogger := logger.With().Str("target", target.Name).Logger()
+				results[i], _ = PingConfigContext(ctx, target.Config, &targetLogger)
+				results[i].Target = target.Name
+			}
+		}()
+	}
+
+	// Targets not yet started when ctx ends are reported as failed
+	for i := range targets {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			err := wrapError(ctx.Err())
+			results[i] = PingResult{Target: targets[i].Name, Error: err.Error(), ErrorClass: FailureClass(err)}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// otherFingerprint collects statements once the fingerprint limit is reached
+const otherFingerprint = "other"
+
+// latencyBuckets are the upper bounds of the latency histogram, doubling
+// from 50µs to roughly 200s; slower calls fall into a final overflow bucket
+var latencyBuckets = func() []time.Duration {
+	bounds := make([]time.Duration, 23)
+	for i := range bounds {
+		bounds[i] = 50 * time.Microsecond << i
+	}
+	return bounds
+}()
+
+// QueryStatsConfig controls per-fingerprint statistics and their report
+type QueryStatsConfig struct {
+	MaxFingerprints int    `yaml:"max_fingerprints"`
+	ReportInterval  string `yaml:"report_interval"`
+	TopN            int    `yaml:"top_n"`
+}
+
+// FingerprintStats summarizes the calls sharing one query fingerprint
+type FingerprintStats struct {
+	Fingerprint string        `json:"fingerprint"`
+	Query       string        `json:"query"`
+	Count       uint64        `json:"count"`
+	Errors      uint64        `json:"errors"`
+	Total       time.Duration `json:"total"`
+	Max         time.Duration `json:"max"`
+	P50         time.Duration `json:"p50"`
+	P95         time.Duration `json:"p95"`
+	P99         time.Duration `json:"p99"`
+}
+
+// fingerprintEntry accumulates calls for one fingerprint
+type fingerprintEntry struct {
+	query   string
+	count   uint64
+	errors  uint64
+	total   time.Duration
+	max     time.Duration
+	buckets [24]uint64
+}
+
+// observe records a single call
+func (e *fingerprintEntry) observe(duration time.Duration, failed bool) {
+	e.count++
+	if failed {
+		e.errors++
+	}
+	e.total += duration
+	if duration > e.max {
+		e.max = duration
+	}
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return duration <= latencyBuckets[i] })
+	e.buckets[i]++
+}
+
+// percentile estimates the q-th latency quantile as the upper bound of
+// the bucket containing it, capped at the slowest observed call
+func (e *fingerprintEntry) percentile(q float64) time.Duration {
+	if e.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(e.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range e.buckets {
+		seen += n
+		if seen >= rank {
+			if i < len(latencyBuckets) && latencyBuckets[i] < e.max {
+				return latencyBuckets[i]
+			}
+			return e.max
+		}
+	}
+	return e.max
+}
+
+// queryStats tracks latency per query fingerprint
+type queryStats struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[string]*fingerprintEntry
+}
+
+// newQueryStats creates a tracker holding at most limit fingerprints
+func newQueryStats(limit int) *queryStats {
+	if limit <= 0 {
+		limit = 1000
+	}
+	return &queryStats{limit: limit, entries: make(map[string]*fingerprintEntry)}
+}
+
+// observe records a call of query
+func (s *queryStats) observe(query string, duration time.Duration, failed bool) {
+	normalized := NormalizeQuery(query)
+	fingerprint := hashNormalized(normalized)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[fingerprint]
+	if !ok {
+		if len(s.entries) >= s.limit {
+			fingerprint, normalized = otherFingerprint, ""
+			entry = s.entries[fingerprint]
+		}
+		if entry == nil {
+			entry = &fingerprintEntry{query: normalized}
+			s.entries[fingerprint] = entry
+		}
+	}
+	entry.observe(duration, failed)
+}
+
+// snapshot returns the statistics of every fingerprint, ordered by total
+// time spent, highest first
+func (s *queryStats) snapshot() []FingerprintStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]FingerprintStats, 0, len(s.entries))
+	for fingerprint, e := range s.entries {
+		stats = append(stats, FingerprintStats{
+			Fingerprint: fingerprint,
+			Query:       e.query,
+			Count:       e.count,
+			Errors:      e.errors,
+			Total:       e.total,
+			Max:         e.max,
+			P50:         e.percentile(0.50),
+			P95:         e.percentile(0.95),
+			P99:         e.percentile(0.99),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Total > stats[j].Total })
+	return stats
+}
+
+// statsHook feeds completed statements into the query statistics
+type statsHook struct {
+	stats *queryStats
+}
+
+// Before is a no-op; statistics are recorded once the call completes
+func (h statsHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+// After records the statement's latency and outcome
+func (h statsHook) After(ctx context.Context, event *QueryEvent) {
+	switch event.Op {
+	case OpQuery, OpQueryRow, OpExec:
+		h.stats.observe(event.Query, event.Duration, event.Err != nil)
+	}
+}
+
+// startStatsReport logs the top N fingerprints by total time every
+// interval until the connection is closed
+func (dc *DatabaseConnection) startStatsReport(interval time.Duration, topN int) {
+	if topN <= 0 {
+		topN = 10
+	}
+
+	dc.stopReport = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-dc.stopReport:
+				return
+			case <-ticker.C:
+				dc.logTopQueries(topN)
+			}
+		}
+	}()
+}
+
+// logTopQueries writes one info entry per fingerprint for the top n
+// fingerprints by total time
+func (dc *DatabaseConnection) logTopQueries(n int) {
+	stats := dc.queryStats.snapshot()
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+
+	for i, s := range stats {
+		dc.Logger.Info().
+			Int("rank", i+1).
+			Str("fingerprint", s.Fingerprint).
+			Str("query", s.Query).
+			Uint64("count", s.Count).
+			Uint64("errors", s.Errors).
+			Dur("total", s.Total).
+			Dur("p50", s.P50).
+			Dur("p95", s.P95).
+			Dur("p99", s.P99).
+			Dur("max", s.Max).
+			Msg("Top query by total time")
+	}
+}
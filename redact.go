@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Redaction modes for logged query arguments
+const (
+	// RedactTypes logs only each argument's type and length
+	RedactTypes = "types"
+	// RedactRules logs argument values, masking those matched by a rule
+	RedactRules = "rules"
+	// RedactNone logs argument values unchanged
+	RedactNone = "none"
+)
+
+// redactedValue replaces masked argument values in logs
+const redactedValue = "[REDACTED]"
+
+// defaultRedactNames are named parameters that are always masked in
+// rules mode
+var defaultRedactNames = []string{"password", "passwd", "secret", "token", "api_key", "apikey"}
+
+// RedactionConfig controls how query arguments appear in logs. Positions
+// are keyed by StatementFingerprint, as argument positions only hold for
+// one exact IN list length or number of VALUES rows.
+type RedactionConfig struct {
+	Mode      string   `yaml:"mode"`
+	Names     []string `yaml:"names"`
+	Patterns  []string `yaml:"patterns"`
+	Positions []struct {
+		Fingerprint string `yaml:"fingerprint"`
+		Args        []int  `yaml:"args"`
+	} `yaml:"positions"`
+}
+
+// redactor masks sensitive query arguments before they are logged
+type redactor struct {
+	mode      string
+	names     map[string]bool
+	patterns  []*regexp.Regexp
+	positions map[string]map[int]bool
+}
+
+// newRedactor compiles the redaction config. An empty mode defaults to
+// RedactTypes so argument values never reach the logs unless asked for.
+func newRedactor(config RedactionConfig) (*redactor, error) {
+	r := &redactor{
+		mode:      config.Mode,
+		names:     make(map[string]bool),
+		positions: make(map[string]map[int]bool),
+	}
+
+	switch r.mode {
+	case "":
+		r.mode = RedactTypes
+	case RedactTypes, RedactRules, RedactNone:
+	default:
+		return nil, fmt.Errorf("invalid redaction mode: %s", config.Mode)
+	}
+
+	for _, name := range append(defaultRedactNames, config.Names...) {
+		r.names[strings.ToLower(name)] = true
+	}
+
+	for _, pattern := range config.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	for _, rule := range config.Positions {
+		if r.positions[rule.Fingerprint] == nil {
+			r.positions[rule.Fingerprint] = make(map[int]bool)
+		}
+		for _, pos := range rule.Args {
+			r.positions[rule.Fingerprint][pos] = true
+		}
+	}
+
+	return r, nil
+}
+
+// Args returns a log-safe representation of the arguments of query
+func (r *redactor) Args(query string, args []interface{}) []interface{} {
+	if len(args) == 0 || r.mode == RedactNone {
+		return args
+	}
+
+	var positions map[int]bool
+	if r.mode == RedactRules && len(r.positions) > 0 {
+		positions = r.positions[StatementFingerprint(query)]
+	}
+
+	safe := make([]interface{}, len(args))
+	for i, arg := range args {
+		name := ""
+		value := arg
+		if named, ok := arg.(sql.NamedArg); ok {
+			name = named.Name
+			value = named.Value
+		}
+
+		var out interface{}
+		switch {
+		case r.mode == RedactTypes:
+			out = describeArg(value)
+		case positions[i+1], name != "" && r.names[strings.ToLower(name)], r.matches(value):
+			out = redactedValue
+		default:
+			out = value
+		}
+
+		if name != "" {
+			out = fmt.Sprintf("%s=%v", name, out)
+		}
+		safe[i] = out
+	}
+
+	return safe
+}
+
+// matches reports whether a string-like value matches a redaction pattern
+func (r *redactor) matches(value interface{}) bool {
+	if len(r.patterns) == 0 {
+		return false
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		return false
+	}
+
+	for _, re := range r.patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeArg returns an argument's type and, for strings and byte
+// slices, its length
+func describeArg(value interface{}) string {
+	if valuer, ok := value.(driver.Valuer); ok {
+		if v, err := valuer.Value(); err == nil {
+			value = v
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return fmt.Sprintf("string(%d)", len(v))
+	case []byte:
+		return fmt.Sprintf("[]byte(%d)", len(v))
+	case time.Time:
+		return "time.Time"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// positionRedactor builds a rules-mode redactor masking args of the
+// statement with the given fingerprint
+func positionRedactor(t *testing.T, fingerprint string, args ...int) *redactor {
+	t.Helper()
+	var config RedactionConfig
+	positions := make([]string, len(args))
+	for i, arg := range args {
+		positions[i] = strconv.Itoa(arg)
+	}
+	doc := fmt.Sprintf("mode: rules\npositions:\n  - {fingerprint: %q, args: [%s]}\n",
+		fingerprint, strings.Join(positions, ", "))
+	if err := yaml.Unmarshal([]byte(doc), &config); err != nil {
+		t.Fatalf("parse redaction config: %v", err)
+	}
+	r, err := newRedactor(config)
+	if err != nil {
+		t.Fatalf("newRedactor: %v", err)
+	}
+	return r
+}
+
+func TestRedactPositions(t *testing.T) {
+	const (
+		insertOne = "INSERT INTO users (name, password) VALUES (?, ?)"
+		insertTwo = "INSERT INTO users (name, password) VALUES (?, ?), (?, ?)"
+		inTwo     = "SELECT * FROM sessions WHERE id IN (?, ?) AND token = ?"
+		inThree   = "SELECT * FROM sessions WHERE id IN (?, ?, ?) AND token = ?"
+	)
+
+	tests := []struct {
+		name  string
+		rule  string
+		ruled []int
+		query string
+		args  []interface{}
+		want  []interface{}
+	}{
+		{
+			"single-row insert", insertOne, []int{2},
+			insertOne, []interface{}{"a", "pw1"},
+			[]interface{}{"a", redactedValue},
+		},
+		{
+			"multi-row insert with its own rule", insertTwo, []int{2, 4},
+			insertTwo, []interface{}{"a", "pw1", "b", "pw2"},
+			[]interface{}{"a", redactedValue, "b", redactedValue},
+		},
+		{
+			"single-row rule doesn't half-cover a multi-row insert", insertOne, []int{2},
+			insertTwo, []interface{}{"a", "pw1", "b", "pw2"},
+			[]interface{}{"a", "pw1", "b", "pw2"},
+		},
+		{
+			"in list", inTwo, []int{3},
+			inTwo, []interface{}{1, 2, "tok"},
+			[]interface{}{1, 2, redactedValue},
+		},
+		{
+			"in list rule doesn't shift onto a longer list", inTwo, []int{3},
+			inThree, []interface{}{1, 2, 3, "tok"},
+			[]interface{}{1, 2, 3, "tok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := positionRedactor(t, StatementFingerprint(tt.rule), tt.ruled...)
+			if got := r.Args(tt.query, tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Args() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatementFingerprintKeepsLists(t *testing.T) {
+	pairs := [][2]string{
+		{"INSERT INTO t (a) VALUES (?)", "INSERT INTO t (a) VALUES (?), (?)"},
+		{"SELECT * FROM t WHERE id IN (?)", "SELECT * FROM t WHERE id IN (?, ?)"},
+	}
+	for _, p := range pairs {
+		if Fingerprint(p[0]) != Fingerprint(p[1]) {
+			t.Errorf("Fingerprint differs for %q and %q", p[0], p[1])
+		}
+		if StatementFingerprint(p[0]) == StatementFingerprint(p[1]) {
+			t.Errorf("StatementFingerprint matches for %q and %q", p[0], p[1])
+		}
+	}
+}
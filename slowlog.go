@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Limits on the EXPLAINs issued for slow queries, so a burst of slow
+// queries against a struggling server doesn't add a query of its own for
+// each one
+const (
+	explainTimeout     = 2 * time.Second
+	explainConcurrency = 2
+	explainInterval    = time.Second
+)
+
+// explainLimiter bounds the number of EXPLAINs running at once and the
+// rate at which new ones start
+type explainLimiter struct {
+	slots    chan struct{}
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newExplainLimiter allows concurrency EXPLAINs at once, started at most
+// once per interval
+func newExplainLimiter(concurrency int, interval time.Duration) *explainLimiter {
+	return &explainLimiter{slots: make(chan struct{}, concurrency), interval: interval}
+}
+
+// acquire reserves a slot without waiting, reporting false when all slots
+// are busy or the last EXPLAIN started too recently. A successful caller
+// must call release when done.
+func (l *explainLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.next) {
+		return false
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		return false
+	}
+	l.next = now.Add(l.interval)
+	return true
+}
+
+// release frees a slot reserved by acquire
+func (l *explainLimiter) release() {
+	<-l.slots
+}
+
+// packagePrefix is this package's import path followed by a dot, used to
+// skip internal frames when locating the caller of a query
+var packagePrefix = func() string {
+	name := runtime.FuncForPC(reflect.ValueOf(NormalizeQuery).Pointer()).Name()
+	return strings.TrimSuffix(name, "NormalizeQuery")
+}()
+
+// queryCaller returns the function and file:line of the first stack
+// frame outside this package
+func queryCaller() (function, location string) {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) {
+			return frame.Function, fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "", ""
+		}
+	}
+}
+
+// logSlowQuery logs query to logger at warn level when duration exceeds
+// the configured slow_query_threshold. rowsAffected is -1 when unknown.
+func (dc *DatabaseConnection) logSlowQuery(logger zerolog.Logger, query string, args []interface{}, duration time.Duration, rowsAffected int64) {
+	if dc.slowQueryThreshold <= 0 || duration < dc.slowQueryThreshold {
+		return
+	}
+
+	function, location := queryCaller()
+	event := logger.Warn().
+		Str("query", query).
+		Str("fingerprint", Fingerprint(query)).
+		Str("normalized_query", NormalizeQuery(query)).
+		Dur("duration", duration).
+		Dur("threshold", dc.slowQueryThreshold).
+		Str("caller", function).
+		Str("location", location)
+
+	if rowsAffected >= 0 {
+		event = event.Int64("rows_affected", rowsAffected)
+	}
+
+	if !dc.Config.Database.ExplainSlowQueries || !isSelect(query) {
+		event.Msg("Slow query")
+		return
+	}
+	if !dc.explains.acquire() {
+		event.Str("explain_skipped", "rate limited").Msg("Slow query")
+		return
+	}
+
+	// The caller may still hold the query's connection until its rows are
+	// read, so capture the plan on another connection in the background
+	go func() {
+		defer dc.explains.release()
+		plan, err := dc.explain(query, args)
+		if err != nil {
+			event = event.AnErr("explain_error", err)
+		} else {
+			event = event.Str("plan", plan)
+		}
+		event.Msg("Slow query")
+	}()
+}
+
+// isSelect reports whether query is a read-only SELECT (or WITH ... SELECT)
+func isSelect(query string) bool {
+	normalized := NormalizeQuery(query)
+	return strings.HasPrefix(normalized, "select ") || strings.HasPrefix(normalized, "with ")
+}
+
+// explain returns the execution plan for query, one row per line
+func (dc *DatabaseConnection) explain(query string, args []interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+
+	prefix := "EXPLAIN "
+	if dc.dialect.Name() == "sqlite3" {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+
+	rows, err := dc.DB.QueryContext(ctx, prefix+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			fields[i] = v.String
+		}
+		lines = append(lines, strings.Join(fields, " | "))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// StmtCacheStats reports prepared statement cache activity
+type StmtCacheStats struct {
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// stmtCache is an LRU cache of prepared statements keyed by SQL text.
+// Statements belong to the *sql.DB they were prepared on, so the cache is
+// purged whenever it is asked for a statement on a different pool.
+// Callers hold a reference from get until they release it; an evicted or
+// purged statement is closed once its last reference is released.
+type stmtCache struct {
+	mu       sync.Mutex
+	capacity int
+	db       *sql.DB
+	order    *list.List
+	entries  map[string]*list.Element
+	stats    StmtCacheStats
+}
+
+// stmtEntry is a single cached statement
+type stmtEntry struct {
+	query string
+	stmt  *sql.Stmt
+
+	// refs counts callers between get and release; dropped is set once
+	// the entry has left the cache
+	refs    int
+	dropped bool
+}
+
+// newStmtCache creates a cache holding at most capacity statements
+func newStmtCache(capacity int) *stmtCache {
+	return &stmtCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached statement for query on db, preparing it on a
+// miss. The caller must call release once it has started its Exec or
+// Query on the statement; database/sql keeps a statement with running
+// calls or open rows alive after Close.
+func (c *stmtCache) get(ctx context.Context, db *sql.DB, query string) (stmt *sql.Stmt, release func(), err error) {
+	c.mu.Lock()
+	if c.db != db {
+		c.purgeLocked()
+		c.db = db
+	}
+	if elem, ok := c.entries[query]; ok {
+		c.order.MoveToFront(elem)
+		c.stats.Hits++
+		entry := c.acquireLocked(elem)
+		c.mu.Unlock()
+		return entry.stmt, func() { c.release(entry) }, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// Prepare outside the lock so a slow round trip doesn't block other callers
+	stmt, err = db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.db != db {
+		// The pool was replaced while preparing; don't cache a stale statement
+		return stmt, func() { stmt.Close() }, nil
+	}
+	if elem, ok := c.entries[query]; ok {
+		// Another caller prepared the same query concurrently
+		stmt.Close()
+		c.order.MoveToFront(elem)
+		entry := c.acquireLocked(elem)
+		return entry.stmt, func() { c.release(entry) }, nil
+	}
+
+	entry := &stmtEntry{query: query, stmt: stmt, refs: 1}
+	c.entries[query] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.dropLocked(c.order.Remove(oldest).(*stmtEntry))
+		c.stats.Evictions++
+	}
+
+	return stmt, func() { c.release(entry) }, nil
+}
+
+// acquireLocked takes a reference to the entry in elem
+func (c *stmtCache) acquireLocked(elem *list.Element) *stmtEntry {
+	entry := elem.Value.(*stmtEntry)
+	entry.refs++
+	return entry
+}
+
+// release drops a reference taken by get, closing the statement if it
+// has left the cache and this was the last reference
+func (c *stmtCache) release(entry *stmtEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.dropped && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// dropLocked removes entry from the index and closes its statement, or
+// leaves that to the last caller still holding a reference
+func (c *stmtCache) dropLocked(entry *stmtEntry) {
+	delete(c.entries, entry.query)
+	entry.dropped = true
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// purge drops every cached statement, closing those not in use
+func (c *stmtCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purgeLocked()
+}
+
+// purgeLocked is purge with c.mu already held
+func (c *stmtCache) purgeLocked() {
+	for _, elem := range c.entries {
+		c.dropLocked(elem.Value.(*stmtEntry))
+	}
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// snapshot returns the current cache statistics
+func (c *stmtCache) snapshot() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// TxStmt returns a transaction-specific version of the cached prepared
+// statement for query. The statement is closed when tx commits or rolls
+// back. Without a statement cache the query is prepared on tx directly.
+func (dc *DatabaseConnection) TxStmt(ctx context.Context, tx *sql.Tx, query string) (*sql.Stmt, error) {
+	if dc.stmts == nil {
+		return tx.PrepareContext(ctx, query)
+	}
+	stmt, release, err := dc.stmts.get(ctx, dc.DB, query)
+	if err != nil {
+		return nil, err
+	}
+	// The transaction's statement keeps the cached one alive until it closes
+	defer release()
+	return tx.StmtContext(ctx, stmt), nil
+}
+
+// execDB executes query on the pool, through the statement cache if
+// enabled. Only uncached statements carry the sqlcommenter comment: a
+// per-request comment would make every statement text unique and leave
+// nothing to reuse.
+func (dc *DatabaseConnection) execDB(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if dc.stmts == nil {
+		return dc.DB.ExecContext(ctx, dc.annotate(ctx, query), args...)
+	}
+	stmt, release, err := dc.stmts.get(ctx, dc.DB, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.ExecContext(ctx, args...)
+}
+
+// queryDB runs query on the pool, through the statement cache if enabled
+// and otherwise annotated like execDB
+func (dc *DatabaseConnection) queryDB(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if dc.stmts == nil {
+		return dc.DB.QueryContext(ctx, dc.annotate(ctx, query), args...)
+	}
+	stmt, release, err := dc.stmts.get(ctx, dc.DB, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.QueryContext(ctx, args...)
+}
+
+// queryRowDB runs a single-row query on the pool, through the statement
+// cache if enabled and otherwise annotated like execDB. Prepare errors
+// fall back to an unprepared query so they surface from Row.Scan.
+func (dc *DatabaseConnection) queryRowDB(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if dc.stmts != nil {
+		if stmt, release, err := dc.stmts.get(ctx, dc.DB, query); err == nil {
+			defer release()
+			return stmt.QueryRowContext(ctx, args...)
+		}
+	}
+	return dc.DB.QueryRowContext(ctx, dc.annotate(ctx, query), args...)
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Default timeouts used when the config leaves them empty
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultPingTimeout    = 5 * time.Second
+)
+
+// TimeoutsConfig bounds connection setup, pings, statements and
+// transactions. Values are Go durations; empty uses the default and "0"
+// disables the timeout.
+type TimeoutsConfig struct {
+	// Connect bounds establishing a server connection, passed to the
+	// driver as connect_timeout (Postgres) or timeout (MySQL). SQLite has
+	// no connect step. Defaults to 10s.
+	Connect string `yaml:"connect"`
+
+	// Ping bounds the ping made when connecting and Ping calls whose
+	// context has no deadline. Defaults to 5s.
+	Ping string `yaml:"ping"`
+
+	// Query bounds Exec calls whose context has no deadline. Queries are
+	// not bounded, as their rows are read after the call returns; pass a
+	// context with a deadline to bound them. Defaults to none.
+	Query string `yaml:"query"`
+
+	// Transaction bounds transactions, from BeginTx to Commit or
+	// Rollback, whose context has no deadline. Defaults to none.
+	Transaction string `yaml:"transaction"`
+}
+
+// Override returns t with each non-empty field of o replacing its own
+func (t TimeoutsConfig) Override(o TimeoutsConfig) TimeoutsConfig {
+	if o.Connect != "" {
+		t.Connect = o.Connect
+	}
+	if o.Ping != "" {
+		t.Ping = o.Ping
+	}
+	if o.Query != "" {
+		t.Query = o.Query
+	}
+	if o.Transaction != "" {
+		t.Transaction = o.Transaction
+	}
+	return t
+}
+
+// RegisterFlags defines -connect-timeout, -ping-timeout, -query-timeout
+// and -tx-timeout on fs, storing their values in t for use with Override
+func (t *TimeoutsConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&t.Connect, "connect-timeout", "", "Connect timeout, overriding the config (0 disables)")
+	fs.StringVar(&t.Ping, "ping-timeout", "", "Ping timeout, overriding the config (0 disables)")
+	fs.StringVar(&t.Query, "query-timeout", "", "Default Exec timeout, overriding the config (0 disables)")
+	fs.StringVar(&t.Transaction, "tx-timeout", "", "Default transaction timeout, overriding the config (0 disables)")
+}
+
+// timeouts holds the parsed values of a TimeoutsConfig; zero means no
+// timeout
+type timeouts struct {
+	connect     time.Duration
+	ping        time.Duration
+	query       time.Duration
+	transaction time.Duration
+}
+
+// parse validates the configured timeouts and applies the defaults
+func (t TimeoutsConfig) parse() (timeouts, error) {
+	var parsed timeouts
+	var err error
+	if parsed.connect, err = parseTimeout("connect", t.Connect, defaultConnectTimeout); err != nil {
+		return parsed, err
+	}
+	if parsed.ping, err = parseTimeout("ping", t.Ping, defaultPingTimeout); err != nil {
+		return parsed, err
+	}
+	if parsed.query, err = parseTimeout("query", t.Query, 0); err != nil {
+		return parsed, err
+	}
+	if parsed.transaction, err = parseTimeout("transaction", t.Transaction, 0); err != nil {
+		return parsed, err
+	}
+	return parsed, nil
+}
+
+// parseTimeout parses one timeout, returning def when value is empty
+func parseTimeout(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeouts %s: %v", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid timeouts %s: negative duration %s", name, value)
+	}
+	return d, nil
+}
+
+// withTimeout bounds ctx by d unless d is zero or ctx already has a
+// deadline
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// connectTimeout returns the connect timeout of config for building a
+// DSN. connect has already rejected invalid values, so a parse error
+// falls back to the default.
+func connectTimeout(config *Config) time.Duration {
+	parsed, err := config.Database.Timeouts.parse()
+	if err != nil {
+		return defaultConnectTimeout
+	}
+	return parsed.connect
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies this package as the instrumentation library
+const tracerName = "your_module_name/database"
+
+// dbSystems maps driver names to OpenTelemetry db.system values
+var dbSystems = map[string]string{
+	"postgres": "postgresql",
+	"mysql":    "mysql",
+	"sqlite3":  "sqlite",
+}
+
+// spanKey and txSpanKey store the spans started by TracingHook in the
+// call and transaction contexts
+type spanKey struct{}
+type txSpanKey struct{}
+
+// TracingHook creates an OpenTelemetry client span for every query,
+// exec and prepare, and one span covering each transaction from begin to
+// commit or rollback. Statements are recorded in normalized form so
+// literal values never reach the tracing backend.
+type TracingHook struct {
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+// NewTracingHook creates a tracing hook for dc using tp. A nil tp uses
+// the global provider from otel.GetTracerProvider. Tests can pass an SDK
+// provider backed by tracetest.NewInMemoryExporter to inspect spans
+// without a collector.
+func NewTracingHook(dc *DatabaseConnection, tp trace.TracerProvider) *TracingHook {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	system, ok := dbSystems[dc.Config.Database.Driver]
+	if !ok {
+		system = dc.Config.Database.Driver
+	}
+
+	name := dc.Config.Database.DBName
+	if dc.Config.Database.Driver == "sqlite3" {
+		name = dc.Config.Database.Filepath
+	}
+
+	return &TracingHook{
+		tracer: tp.Tracer(tracerName),
+		attrs: []attribute.KeyValue{
+			attribute.String("db.system", system),
+			attribute.String("db.name", name),
+		},
+	}
+}
+
+// EnableTracing registers a TracingHook using tp, or the global provider
+// when tp is nil
+func (dc *DatabaseConnection) EnableTracing(tp trace.TracerProvider) {
+	dc.AddHook(NewTracingHook(dc, tp))
+}
+
+// Before starts a span for the call; transactions get a span that stays
+// open until commit or rollback
+func (h *TracingHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	switch event.Op {
+	case OpCommit, OpRollback:
+		// Recorded on the transaction span in After
+		return ctx
+	case OpBegin:
+		ctx, span := h.tracer.Start(ctx, "db.transaction",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.attrs...))
+		return context.WithValue(ctx, txSpanKey{}, span)
+	}
+
+	attrs := append([]attribute.KeyValue{
+		attribute.String("db.operation", event.Op),
+		attribute.String("db.statement", NormalizeQuery(event.Query)),
+	}, h.attrs...)
+	if event.Prepared {
+		attrs = append(attrs, attribute.Bool("db.prepared", true))
+	}
+
+	ctx, span := h.tracer.Start(ctx, "db."+event.Op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// After records the outcome and ends the span started by Before
+func (h *TracingHook) After(ctx context.Context, event *QueryEvent) {
+	switch event.Op {
+	case OpBegin, OpCommit, OpRollback:
+		span, ok := ctx.Value(txSpanKey{}).(trace.Span)
+		if !ok {
+			return
+		}
+		if event.Op != OpBegin {
+			span.AddEvent(event.Op)
+		}
+		recordError(span, event.Err)
+		if event.Op != OpBegin || event.Err != nil {
+			span.End()
+		}
+		return
+	}
+
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if event.RowsAffected >= 0 {
+		span.SetAttributes(attribute.Int64("db.rows_affected", event.RowsAffected))
+	}
+	recordError(span, event.Err)
+	span.End()
+}
+
+// recordError marks span as failed when err is set
+func recordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracedConnection opens a SQLite database in a temporary directory
+// with tracing exported to an in-memory exporter
+func newTracedConnection(t *testing.T) (*DatabaseConnection, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	var config Config
+	config.Database.Driver = "sqlite3"
+	config.Database.Filepath = filepath.Join(t.TempDir(), "trace.db")
+	config.Database.Pool.MaxOpenConns = 1
+	config.Database.Pool.ConnMaxLifetime = "5m"
+	config.Database.Pool.ConnMaxIdleTime = "1m"
+
+	logger := zerolog.Nop()
+	dc, err := Connect(&config, &logger)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { dc.Close() })
+
+	if _, err := dc.DB.Exec("CREATE TABLE data_domains (domain_name TEXT PRIMARY KEY)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	dc.EnableTracing(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return dc, exporter
+}
+
+// spanAttr returns the value of key on span, or "" when it is missing
+func spanAttr(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracingQueryAndExec(t *testing.T) {
+	dc, exporter := newTracedConnection(t)
+	ctx := context.Background()
+
+	rows, err := dc.QueryContext(ctx, "SELECT domain_name FROM data_domains WHERE domain_name = 'sales'")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	rows.Close()
+	if _, err := dc.ExecContext(ctx, "INSERT INTO data_domains (domain_name) VALUES (?)", "hr"); err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if _, err := dc.ExecContext(ctx, "INSERT INTO missing_table (domain_name) VALUES (?)", "hr"); err == nil {
+		t.Fatal("exec into a missing table succeeded")
+	}
+
+	tests := []struct {
+		name      string
+		statement string
+		affected  string
+		status    codes.Code
+	}{
+		{"db.query", "select domain_name from data_domains where domain_name = ?", "", codes.Unset},
+		{"db.exec", "insert into data_domains (domain_name) values (?)", "1", codes.Unset},
+		{"db.exec", "insert into missing_table (domain_name) values (?)", "", codes.Error},
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != len(tests) {
+		t.Fatalf("got %d spans, want %d", len(spans), len(tests))
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name != tt.name {
+			t.Errorf("span %d: name %q, want %q", i, span.Name, tt.name)
+		}
+		if got := spanAttr(span, "db.statement"); got != tt.statement {
+			t.Errorf("span %d: db.statement %q, want %q", i, got, tt.statement)
+		}
+		if got := spanAttr(span, "db.system"); got != "sqlite" {
+			t.Errorf("span %d: db.system %q, want sqlite", i, got)
+		}
+		if got := spanAttr(span, "db.rows_affected"); got != tt.affected {
+			t.Errorf("span %d: db.rows_affected %q, want %q", i, got, tt.affected)
+		}
+		if span.Status.Code != tt.status {
+			t.Errorf("span %d: status %v, want %v", i, span.Status.Code, tt.status)
+		}
+	}
+}
+
+func TestTracingTransaction(t *testing.T) {
+	tests := []struct {
+		name       string
+		table      string
+		finish     func(*Tx) error
+		event      string
+		execStatus codes.Code
+	}{
+		{"commit", "data_domains", (*Tx).Commit, OpCommit, codes.Unset},
+		{"rollback after failure", "missing_table", (*Tx).Rollback, OpRollback, codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc, exporter := newTracedConnection(t)
+			ctx := context.Background()
+
+			tx, err := dc.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatalf("begin: %v", err)
+			}
+			// Exec runs on the transaction's context, under its span
+			_, err = tx.Exec("INSERT INTO "+tt.table+" (domain_name) VALUES (?)", "hr")
+			if (err != nil) != (tt.execStatus == codes.Error) {
+				t.Fatalf("exec: %v", err)
+			}
+			if err := tt.finish(tx); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 2 {
+				t.Fatalf("got %d spans, want 2", len(spans))
+			}
+			exec, txSpan := spans[0], spans[1]
+			if exec.Name != "db.exec" || txSpan.Name != "db.transaction" {
+				t.Fatalf("spans %q and %q, want db.exec and db.transaction", exec.Name, txSpan.Name)
+			}
+			if exec.Parent.SpanID() != txSpan.SpanContext.SpanID() {
+				t.Error("exec span is not a child of the transaction span")
+			}
+			if exec.Status.Code != tt.execStatus {
+				t.Errorf("exec status %v, want %v", exec.Status.Code, tt.execStatus)
+			}
+			if len(txSpan.Events) != 1 || txSpan.Events[0].Name != tt.event {
+				t.Errorf("transaction events %v, want one %s event", txSpan.Events, tt.event)
+			}
+			if txSpan.Status.Code != codes.Unset {
+				t.Errorf("transaction status %v, want unset", txSpan.Status.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Tx is a database transaction whose statements run through the
+// connection's hook chain
+type Tx struct {
+	Tx     *sql.Tx
+	dc     *DatabaseConnection
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   bool
+}
+
+// Begin starts a transaction with default options
+func (dc *DatabaseConnection) Begin() (*Tx, error) {
+	return dc.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction. The context returned by the hooks'
+// Before callbacks is kept for Commit and Rollback and for the Tx
+// methods that take no context. When ctx has no deadline the transaction
+// timeout applies, after which database/sql rolls the transaction back.
+func (dc *DatabaseConnection) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	event := newEvent(OpBegin, "", nil)
+	event.InTx = true
+
+	ctx, cancel := withTimeout(ctx, dc.timeouts.transaction)
+	ctx = dc.before(ctx, event)
+	tx, err := dc.DB.BeginTx(ctx, opts)
+	err = wrapError(err)
+	event.Err = err
+	dc.after(ctx, event)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, dc: dc, ctx: ctx, cancel: cancel}, nil
+}
+
+// Context returns the context the transaction was started with
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+// event creates a hook event flagged as running inside the transaction
+func (tx *Tx) event(op, query string, args []interface{}) *QueryEvent {
+	event := newEvent(op, query, args)
+	event.InTx = true
+	return event
+}
+
+// Query executes a query inside the transaction
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(tx.ctx, query, args...)
+}
+
+// QueryContext executes a query inside the transaction
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.dc.runQuery(ctx, tx.event(OpQuery, query, args), func(ctx context.Context) (*sql.Rows, error) {
+		return tx.Tx.QueryContext(ctx, tx.dc.annotate(ctx, query), args...)
+	})
+}
+
+// QueryRow executes a single-row query inside the transaction
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.QueryRowContext(tx.ctx, query, args...)
+}
+
+// QueryRowContext executes a single-row query inside the transaction
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.dc.runQueryRow(ctx, tx.event(OpQueryRow, query, args), func(ctx context.Context) *sql.Row {
+		return tx.Tx.QueryRowContext(ctx, tx.dc.annotate(ctx, query), args...)
+	})
+}
+
+// Exec executes a modification inside the transaction
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(tx.ctx, query, args...)
+}
+
+// ExecContext executes a modification inside the transaction
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.dc.runExec(ctx, tx.event(OpExec, query, args), func(ctx context.Context) (sql.Result, error) {
+		return tx.Tx.ExecContext(ctx, tx.dc.annotate(ctx, query), args...)
+	})
+}
+
+// QueryFrom renders b and executes it with Query inside the transaction
+func (tx *Tx) QueryFrom(b SQLBuilder) (*sql.Rows, error) {
+	query, args, err := b.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	return tx.Query(query, args...)
+}
+
+// ExecFrom renders b and executes it with Exec inside the transaction
+func (tx *Tx) ExecFrom(b SQLBuilder) (sql.Result, error) {
+	query, args, err := b.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	return tx.Exec(query, args...)
+}
+
+// Prepare returns a prepared statement bound to the transaction, reusing
+// the connection's statement cache when enabled
+func (tx *Tx) Prepare(ctx context.Context, query string) (*Stmt, error) {
+	event := tx.event(OpPrepare, query, nil)
+	ctx = tx.dc.before(ctx, event)
+	stmt, err := tx.dc.TxStmt(ctx, tx.Tx, query)
+	err = wrapError(err)
+	event.Err = err
+	tx.dc.after(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stmt{Stmt: stmt, dc: tx.dc, query: query, inTx: true}, nil
+}
+
+// Commit commits the transaction
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	defer tx.cancel()
+
+	event := tx.event(OpCommit, "", nil)
+	ctx := tx.dc.before(tx.ctx, event)
+	err := wrapError(tx.Tx.Commit())
+	event.Err = err
+	tx.dc.after(ctx, event)
+	return err
+}
+
+// Rollback aborts the transaction. Rolling back a transaction that has
+// already been committed or rolled back returns sql.ErrTxDone without
+// running the hooks, so it is safe to defer.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	defer tx.cancel()
+
+	event := tx.event(OpRollback, "", nil)
+	ctx := tx.dc.before(tx.ctx, event)
+	err := wrapError(tx.Tx.Rollback())
+	event.Err = err
+	tx.dc.after(ctx, event)
+	return err
+}
+
+// Stmt is a prepared statement whose executions run through the
+// connection's hook chain
+type Stmt struct {
+	Stmt  *sql.Stmt
+	dc    *DatabaseConnection
+	query string
+	inTx  bool
+
+	// release drops the statement cache's reference, for cached statements
+	release func()
+}
+
+// Prepare returns a prepared statement for query, taken from the
+// statement cache when enabled
+func (dc *DatabaseConnection) Prepare(ctx context.Context, query string) (*Stmt, error) {
+	event := newEvent(OpPrepare, query, nil)
+	ctx = dc.before(ctx, event)
+
+	var stmt *sql.Stmt
+	var release func()
+	var err error
+	if dc.stmts != nil {
+		stmt, release, err = dc.stmts.get(ctx, dc.DB, query)
+	} else {
+		stmt, err = dc.DB.PrepareContext(ctx, query)
+	}
+
+	err = wrapError(err)
+	event.Err = err
+	dc.after(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stmt{Stmt: stmt, dc: dc, query: query, release: release}, nil
+}
+
+// event creates a hook event for an execution of the statement
+func (s *Stmt) event(op string, args []interface{}) *QueryEvent {
+	event := newEvent(op, s.query, args)
+	event.InTx = s.inTx
+	event.Prepared = true
+	return event
+}
+
+// QueryContext executes the prepared query
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	return s.dc.runQuery(ctx, s.event(OpQuery, args), func(ctx context.Context) (*sql.Rows, error) {
+		return s.Stmt.QueryContext(ctx, args...)
+	})
+}
+
+// QueryRowContext executes the prepared single-row query
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
+	return s.dc.runQueryRow(ctx, s.event(OpQueryRow, args), func(ctx context.Context) *sql.Row {
+		return s.Stmt.QueryRowContext(ctx, args...)
+	})
+}
+
+// ExecContext executes the prepared modification
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	return s.dc.runExec(ctx, s.event(OpExec, args), func(ctx context.Context) (sql.Result, error) {
+		return s.Stmt.ExecContext(ctx, args...)
+	})
+}
+
+// Close releases the statement. Statements owned by the statement cache
+// stay open for reuse unless they were evicted while in use, in which
+// case the last Close closes them.
+func (s *Stmt) Close() error {
+	if s.release != nil {
+		s.release()
+		s.release = nil
+		return nil
+	}
+	return s.Stmt.Close()
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// WaitOptions controls WaitForDatabase
+type WaitOptions struct {
+	// ReadyQuery, when set, must run without error before the database
+	// counts as ready, e.g. "SELECT 1 FROM technical_identities LIMIT 1"
+	ReadyQuery string
+
+	// InitialBackoff is the first delay between attempts, doubling up to
+	// MaxBackoff. They default to 250ms and 10s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Logger replaces the logger described by the config when not nil
+	Logger *zerolog.Logger
+
+	// OnRetry, when set, is called after each failed attempt with the
+	// delay before the next one
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// WaitForDatabase connects to the database described by config, retrying
+// with exponential backoff until it accepts connections and passes
+// opts.ReadyQuery, or until ctx ends. It returns the open connection, or
+// the last attempt's error. Configuration errors are not retried.
+func WaitForDatabase(ctx context.Context, config *Config, opts WaitOptions) (*DatabaseConnection, error) {
+	backoff := opts.InitialBackoff
+	if backoff <= 0 {
+		backoff = 250 * time.Millisecond
+	}
+	maxBackoff := opts.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		dc, err := tryReady(ctx, config, opts)
+		if err == nil {
+			return dc, nil
+		}
+		if errors.Is(err, ErrConfig) {
+			return nil, err
+		}
+
+		if opts.OnRetry != nil {
+			opts.OnRetry(attempt, err, backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("database not ready after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// tryReady makes one connection attempt and runs the readiness query
+func tryReady(ctx context.Context, config *Config, opts WaitOptions) (*DatabaseConnection, error) {
+	dc, err := ConnectContext(ctx, config, opts.Logger)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReadyQuery == "" {
+		return dc, nil
+	}
+
+	rows, err := dc.QueryContext(ctx, opts.ReadyQuery)
+	if err == nil {
+		err = rows.Close()
+	}
+	if err != nil {
+		dc.Close()
+		return nil, fmt.Errorf("readiness query failed: %w", err)
+	}
+	return dc, nil
+}